@@ -5,15 +5,40 @@ import (
 
 	"github.com/google/martian/log"
 
+	"github.com/erda-project/erda-sourcecov/agent/conf"
 	"github.com/erda-project/erda-sourcecov/agent/core"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/gitrepo"
 )
 
 func main() {
+	conf.Init()
 	log.SetLevel(log.Info)
-	ctx, _ := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := gitrepo.CheckGit()
+	if err != nil {
+		panic(err)
+	}
+
+	err = core.InitCenter()
+	if err != nil {
+		panic(err)
+	}
+
+	err = core.LoadState()
+	if err != nil {
+		log.Errorf("%v", err)
+	}
 
 	core.WatchJacocoPod(ctx)
 	go core.WatchJob(ctx)
+	go func() {
+		err := core.ServeAPI(ctx, conf.Cfg.ApiAddr)
+		if err != nil {
+			log.Errorf("%v", err)
+		}
+	}()
 	select {
 	case <-ctx.Done():
 	}
@@ -1,21 +1,70 @@
 package conf
 
+import "fmt"
+
+const (
+	CenterModeErda       = "erda"
+	CenterModeStandalone = "standalone"
+)
+
 type Conf struct {
-	CenterHost  string `env:"CENTER_HOST" required:"true"`
-	CenterToken string `env:"CENTER_TOKEN" required:"true"`
+	// erda: plans and reports go through erda, standalone: plans are read from StandalonePlanFile
+	// and reports are written to the agent volume
+	CenterMode         string `env:"CENTER_MODE" default:"erda"`
+	StandalonePlanFile string `env:"STANDALONE_PLAN_FILE" default:"/jacoco/work/plan.json"`
+
+	// required in erda mode
+	CenterHost  string `env:"CENTER_HOST"`
+	CenterToken string `env:"CENTER_TOKEN"`
+	ProjectID   uint64 `env:"PROJECT_ID"`
+	OrgName     string `env:"ORG_NAME"`
+	Workspace   string `env:"WORKSPACE"`
 
-	ProjectID uint64 `env:"PROJECT_ID" required:"true"`
 	ProjectNs string `env:"PROJECT_NS" required:"true"`
-	OrgName   string `env:"ORG_NAME" required:"true"`
-	Workspace string `env:"WORKSPACE" required:"true"`
+
+	// listen address of the agent control api
+	ApiAddr string `env:"API_ADDR" default:":8080"`
+	// bearer token of the control api, without it the api is only served to clients in the pod of the agent
+	ApiToken string `env:"API_TOKEN"`
+
+	// comma separated registries pulled over plain http
+	InsecureRegistries string `env:"INSECURE_REGISTRIES"`
+
+	// size budget of the classes and sources extracted from artifacts, kept on the work dir across plans
+	ExtractCacheSizeMB int64 `env:"EXTRACT_CACHE_SIZE_MB" default:"10240"`
 }
 
 const WorkDir = "/jacoco/work"
 const JacocoCliAddr = "/app/jacococli.jar"
-const ExtractCliAddr = "/app/extract-jar.sh"
 
 var Cfg Conf
 
-func init() {
+// Init loads Cfg from the env, it is called by the agent before anything else,
+// so the packages can be loaded without the env of the agent, as by their tests
+func Init() {
 	MustLoad(&Cfg)
+	if err := Cfg.validate(); err != nil {
+		panic(err)
+	}
+}
+
+func (c *Conf) validate() error {
+	if c.CenterMode != CenterModeErda {
+		return nil
+	}
+	for _, item := range []struct {
+		key string
+		set bool
+	}{
+		{"CENTER_HOST", c.CenterHost != ""},
+		{"CENTER_TOKEN", c.CenterToken != ""},
+		{"PROJECT_ID", c.ProjectID != 0},
+		{"ORG_NAME", c.OrgName != ""},
+		{"WORKSPACE", c.Workspace != ""},
+	} {
+		if !item.set {
+			return fmt.Errorf("failed to found required environment variable in %v mode, key: %s", CenterModeErda, item.key)
+		}
+	}
+	return nil
 }
@@ -1,8 +1,8 @@
 package core
 
 import (
+	"crypto/sha1"
 	"fmt"
-	"os"
 
 	"github.com/erda-project/erda-sourcecov/agent/conf"
 )
@@ -19,10 +19,6 @@ func GenSvcJarDir(svcName string) string {
 	return fmt.Sprintf("%v/service/%v", conf.WorkDir, svcName)
 }
 
-func GenSvcJarImageTempDir(svcName string) (string, error) {
-	return os.MkdirTemp("", svcName+"svc-jar-*")
-}
-
 func GenSvcClassDir(svcName string) string {
 	return fmt.Sprintf("%v/class/%v", conf.WorkDir, svcName)
 }
@@ -30,3 +26,35 @@ func GenSvcClassDir(svcName string) string {
 func GenProjectClassDir() string {
 	return fmt.Sprintf("%v/class/_project_", conf.WorkDir)
 }
+
+func GenExtractCacheDir() string {
+	return fmt.Sprintf("%v/cache/extract", conf.WorkDir)
+}
+
+func GenMavenRepoDir() string {
+	return fmt.Sprintf("%v/cache/maven-repository", conf.WorkDir)
+}
+
+func GenGitRepoDir(repoURL string) string {
+	return fmt.Sprintf("%v/git/%x", conf.WorkDir, sha1.Sum([]byte(repoURL)))
+}
+
+func GenStandaloneReportDir() string {
+	return fmt.Sprintf("%v/reports", conf.WorkDir)
+}
+
+func GenProjectExecAddr(planID uint64) string {
+	return fmt.Sprintf("%v/%v/_project_.exec", conf.WorkDir, planID)
+}
+
+func GenSvcReportDir(planID uint64, svcName string) string {
+	return fmt.Sprintf("%v/%v/_report_/services/%v", conf.WorkDir, planID, svcName)
+}
+
+func GenStateAddr() string {
+	return fmt.Sprintf("%v/state.json", conf.WorkDir)
+}
+
+func GenPlanReportDir(planID uint64) string {
+	return fmt.Sprintf("%v/%v/_report_", conf.WorkDir, planID)
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	v1opt "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/jacoco"
+)
+
+// annotations of workloads, pod templates and bare pods to configure the coverage target,
+// the annotations of a workload override the ones of its pod template
+const (
+	// "true" to collect the coverage of the pods, "false" to skip them even if the jacoco env is set
+	AnnotationEnabled = "sourcecov.erda.cloud/enabled"
+	// name of the service in the reports, the app label or the workload name by default
+	AnnotationServiceName = "sourcecov.erda.cloud/service-name"
+	// tcpserver port of the jacoco agent, 6300 by default
+	AnnotationPort = "sourcecov.erda.cloud/port"
+	// container running the jvm, the container with the jacoco env or the first container by default
+	AnnotationContainer = "sourcecov.erda.cloud/container"
+	// comma separated jvms of the pods as container:port or port, overrides the port and container annotations
+	AnnotationEndpoints = "sourcecov.erda.cloud/endpoints"
+	// comma separated dirs of the artifacts in the container, /app by default
+	AnnotationJarPath = "sourcecov.erda.cloud/jar-path"
+	// comma separated globs of the artifacts relative to the jar path, **/*.jar,**/*.war,**/WEB-INF/classes by default
+	AnnotationJarPatterns = "sourcecov.erda.cloud/jar-patterns"
+	// comma separated names of the files and dirs under the jar path not to copy
+	AnnotationJarExcludes = "sourcecov.erda.cloud/jar-excludes"
+	// git repository of the sources of the application, the image source label or the plan repository by default
+	AnnotationGitRepo = "sourcecov.erda.cloud/git-repo"
+	// revision of the sources deployed, the image revision label or the plan head revision by default
+	AnnotationGitRevision = "sourcecov.erda.cloud/git-revision"
+	// comma separated globs of the source roots in the repository, **/src/main/java,**/src/main/kotlin by default
+	AnnotationSourceRoots = "sourcecov.erda.cloud/source-roots"
+)
+
+// target is the coverage settings of a workload or a bare pod
+type target struct {
+	name      string
+	image     string
+	endpoints []Endpoint
+	artifacts ArtifactSearch
+	sources   SourceRepo
+}
+
+// mergeAnnotations returns the annotations of the pod template overridden by the ones of the workload
+func mergeAnnotations(obj v1opt.Object, template *corev1.PodTemplateSpec) map[string]string {
+	var annotations = map[string]string{}
+	if template != nil {
+		for k, v := range template.Annotations {
+			annotations[k] = v
+		}
+	}
+	for k, v := range obj.GetAnnotations() {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// serviceNameOf returns the service name of a workload or a bare pod. The name of the annotation must be
+// a label value as the app label, since it names the dirs of the service and is passed to commands.
+func serviceNameOf(obj v1opt.Object, annotations map[string]string) (string, error) {
+	if name, ok := annotations[AnnotationServiceName]; ok {
+		if errs := validation.IsValidLabelValue(name); name == "" || len(errs) > 0 {
+			return "", fmt.Errorf("invalid %v %q, %v", AnnotationServiceName, name, strings.Join(errs, ", "))
+		}
+		return name, nil
+	}
+	if app := obj.GetLabels()["app"]; app != "" {
+		return app, nil
+	}
+	return obj.GetName(), nil
+}
+
+// targetOf returns the coverage target of a workload or a bare pod, nil if it is not enabled.
+// Without the enabled annotation, the target is enabled by the env OPEN_JACOCO_AGENT or SOURCECOV_ENABLED.
+func targetOf(obj v1opt.Object, annotations map[string]string, spec *corev1.PodSpec) (*target, error) {
+	if len(spec.Containers) <= 0 {
+		return nil, nil
+	}
+
+	enabledContainer := jacocoEnvContainer(spec)
+	switch annotations[AnnotationEnabled] {
+	case "true":
+	case "":
+		if enabledContainer == nil {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	var container = enabledContainer
+	if name := annotations[AnnotationContainer]; name != "" {
+		container = nil
+		for i := range spec.Containers {
+			if spec.Containers[i].Name == name {
+				container = &spec.Containers[i]
+			}
+		}
+		if container == nil {
+			return nil, fmt.Errorf("container %v not found", name)
+		}
+	}
+	if container == nil {
+		container = &spec.Containers[0]
+	}
+
+	var port = jacoco.DefaultPort
+	if value := annotations[AnnotationPort]; value != "" {
+		p, err := parsePort(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %v", AnnotationPort, value)
+		}
+		port = p
+	}
+
+	var endpoints = []Endpoint{{Container: container.Name, Port: port}}
+	if value := annotations[AnnotationEndpoints]; value != "" {
+		var err error
+		endpoints, err = parseEndpoints(value, container.Name, spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %v, %v", AnnotationEndpoints, value, err)
+		}
+		for i := range spec.Containers {
+			if spec.Containers[i].Name == endpoints[0].Container {
+				container = &spec.Containers[i]
+			}
+		}
+	}
+
+	name, err := serviceNameOf(obj, annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts = ArtifactSearch{
+		Roots:    splitList(annotations[AnnotationJarPath]),
+		Patterns: splitList(annotations[AnnotationJarPatterns]),
+		Excludes: splitList(annotations[AnnotationJarExcludes]),
+	}
+
+	return &target{
+		name:      name,
+		image:     container.Image,
+		endpoints: endpoints,
+		artifacts: artifacts,
+		sources: SourceRepo{
+			URL:      annotations[AnnotationGitRepo],
+			Revision: annotations[AnnotationGitRevision],
+			Roots:    splitList(annotations[AnnotationSourceRoots]),
+		},
+	}, nil
+}
+
+// parseEndpoints parses the endpoints annotation, an endpoint without container runs in defaultContainer
+func parseEndpoints(value string, defaultContainer string, spec *corev1.PodSpec) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var endpoint = Endpoint{Container: defaultContainer}
+		portValue := item
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			endpoint.Container = item[:i]
+			portValue = item[i+1:]
+		}
+		port, err := parsePort(portValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %v", portValue)
+		}
+		endpoint.Port = port
+
+		var found bool
+		for _, container := range spec.Containers {
+			if container.Name == endpoint.Container {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("container %v not found", endpoint.Container)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) <= 0 {
+		return nil, fmt.Errorf("no endpoint")
+	}
+	return endpoints, nil
+}
+
+// splitList splits a comma separated annotation
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("port %v out of range", port)
+	}
+	return port, nil
+}
+
+// jacocoEnvContainer returns the container the jacoco agent is enabled in by env
+func jacocoEnvContainer(spec *corev1.PodSpec) *corev1.Container {
+	for i, container := range spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "OPEN_JACOCO_AGENT" && env.Value == "true" {
+				return &spec.Containers[i]
+			}
+			if env.Name == "SOURCECOV_ENABLED" && env.Value == "true" {
+				return &spec.Containers[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1opt "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPodSpec() *corev1.PodSpec {
+	return &corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "sidecar", Image: "sidecar:1"},
+		{Name: "app", Image: "order:1", Env: []corev1.EnvVar{{Name: "OPEN_JACOCO_AGENT", Value: "true"}}},
+	}}
+}
+
+func TestTargetOf(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: v1opt.ObjectMeta{Name: "order-0", Labels: map[string]string{"app": "order"}}}
+	for _, c := range []struct {
+		annotations map[string]string
+		noEnv       bool
+		endpoints   []Endpoint
+		image       string
+		err         string
+	}{
+		{annotations: nil, endpoints: []Endpoint{{"app", 6300}}, image: "order:1"},
+		{annotations: nil, noEnv: true},
+		{annotations: map[string]string{AnnotationEnabled: "false"}},
+		{annotations: map[string]string{AnnotationEnabled: "yes"}},
+		{annotations: map[string]string{AnnotationEnabled: "true"}, noEnv: true, endpoints: []Endpoint{{"sidecar", 6300}}, image: "sidecar:1"},
+		{annotations: map[string]string{AnnotationPort: "6400"}, endpoints: []Endpoint{{"app", 6400}}, image: "order:1"},
+		{annotations: map[string]string{AnnotationPort: "http"}, err: "invalid " + AnnotationPort},
+		{annotations: map[string]string{AnnotationPort: "0"}, err: "invalid " + AnnotationPort},
+		{annotations: map[string]string{AnnotationPort: "65536"}, err: "invalid " + AnnotationPort},
+		{annotations: map[string]string{AnnotationContainer: "sidecar"}, endpoints: []Endpoint{{"sidecar", 6300}}, image: "sidecar:1"},
+		{annotations: map[string]string{AnnotationContainer: "missing"}, err: "container missing not found"},
+		{
+			annotations: map[string]string{AnnotationEndpoints: "6301, sidecar:6302"},
+			endpoints:   []Endpoint{{"app", 6301}, {"sidecar", 6302}},
+			image:       "order:1",
+		},
+		{
+			// the image is of the container of the first endpoint
+			annotations: map[string]string{AnnotationEndpoints: "sidecar:6302,app:6301", AnnotationPort: "6400"},
+			endpoints:   []Endpoint{{"sidecar", 6302}, {"app", 6301}},
+			image:       "sidecar:1",
+		},
+		{annotations: map[string]string{AnnotationEndpoints: "app:"}, err: "invalid port"},
+		{annotations: map[string]string{AnnotationEndpoints: "app:6300:1"}, err: "container app:6300 not found"},
+		{annotations: map[string]string{AnnotationEndpoints: "missing:6300"}, err: "container missing not found"},
+		{annotations: map[string]string{AnnotationEndpoints: " , "}, err: "no endpoint"},
+		{annotations: map[string]string{AnnotationServiceName: "../order"}, err: "invalid " + AnnotationServiceName},
+		{annotations: map[string]string{AnnotationServiceName: "order;reboot"}, err: "invalid " + AnnotationServiceName},
+		{annotations: map[string]string{AnnotationServiceName: ""}, err: "invalid " + AnnotationServiceName},
+	} {
+		spec := testPodSpec()
+		if c.noEnv {
+			spec.Containers[1].Env = nil
+		}
+		target, err := targetOf(pod, c.annotations, spec)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("target of %v error = %v, want %v", c.annotations, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("target of %v error %v", c.annotations, err)
+			continue
+		}
+		if c.endpoints == nil {
+			if target != nil {
+				t.Errorf("target of %v = %+v, want not enabled", c.annotations, target)
+			}
+			continue
+		}
+		if target == nil || !reflect.DeepEqual(target.endpoints, c.endpoints) || target.image != c.image {
+			t.Errorf("target of %v = %+v, want endpoints %v image %v", c.annotations, target, c.endpoints, c.image)
+		}
+	}
+}
+
+func TestTargetOfSettings(t *testing.T) {
+	template := &corev1.PodTemplateSpec{ObjectMeta: v1opt.ObjectMeta{Annotations: map[string]string{
+		AnnotationServiceName: "template-name",
+		AnnotationJarPath:     "/app",
+	}}}
+	workload := &corev1.Pod{ObjectMeta: v1opt.ObjectMeta{Name: "order", Annotations: map[string]string{
+		AnnotationServiceName: "order-service",
+		AnnotationJarPath:     " /app/lib, ,/opt/app ",
+		AnnotationJarPatterns: "**/*.jar",
+		AnnotationSourceRoots: "order/src/main/java",
+		AnnotationGitRevision: "v1.0",
+	}}}
+	target, err := targetOf(workload, mergeAnnotations(workload, template), testPodSpec())
+	if err != nil || target == nil {
+		t.Fatalf("target = %+v, %v", target, err)
+	}
+	if target.name != "order-service" {
+		t.Errorf("name = %v, want the workload annotation", target.name)
+	}
+	if !reflect.DeepEqual(target.artifacts.Roots, []string{"/app/lib", "/opt/app"}) ||
+		!reflect.DeepEqual(target.artifacts.Patterns, []string{"**/*.jar"}) || target.artifacts.Excludes != nil {
+		t.Errorf("artifacts = %+v", target.artifacts)
+	}
+	if target.sources.Revision != "v1.0" || !reflect.DeepEqual(target.sources.Roots, []string{"order/src/main/java"}) {
+		t.Errorf("sources = %+v", target.sources)
+	}
+
+	for _, c := range []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        string
+	}{
+		{"order-7d9f", map[string]string{"app": "order"}, map[string]string{AnnotationServiceName: "order-service"}, "order-service"},
+		{"order-7d9f", map[string]string{"app": "order"}, map[string]string{AnnotationServiceName: "Order_Service.v2"}, "Order_Service.v2"},
+		{"order-7d9f", map[string]string{"app": "order"}, map[string]string{AnnotationServiceName: "order/../../etc"}, ""},
+		{"order-7d9f", map[string]string{"app": "order"}, map[string]string{AnnotationServiceName: "$(id)"}, ""},
+		{"order-7d9f", map[string]string{"app": "order"}, nil, "order"},
+		{"order-7d9f", nil, nil, "order-7d9f"},
+	} {
+		obj := &corev1.Pod{ObjectMeta: v1opt.ObjectMeta{Name: c.name, Labels: c.labels}}
+		got, err := serviceNameOf(obj, c.annotations)
+		if got != c.want || (err != nil) != (c.want == "") {
+			t.Errorf("service name of %v %v %v = %v, %v, want %v", c.name, c.labels, c.annotations, got, err, c.want)
+		}
+	}
+}
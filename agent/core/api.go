@@ -0,0 +1,355 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/martian/log"
+
+	"github.com/erda-project/erda-sourcecov/agent/conf"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractor"
+)
+
+// JobView is the api view of a running job
+type JobView struct {
+	PlanID        uint64                 `json:"planID"`
+	Status        CodeCoverageExecStatus `json:"status"`
+	JobStatus     string                 `json:"jobStatus"`
+	Includes      string                 `json:"includes"`
+	Excludes      string                 `json:"excludes"`
+	GitRepo       string                 `json:"gitRepo,omitempty"`
+	BaseRevision  string                 `json:"baseRevision,omitempty"`
+	HeadRevision  string                 `json:"headRevision,omitempty"`
+	ReportFormats []string               `json:"reportFormats"`
+	ErrorMsg      string                 `json:"errorMsg,omitempty"`
+	LatestExec    string                 `json:"latestExec,omitempty"`
+	LatestReport  *ProjectReport         `json:"latestReport,omitempty"`
+}
+
+// ServiceView is the api view of a watched service
+type ServiceView struct {
+	Name         string         `json:"name"`
+	Image        string         `json:"image"`
+	Endpoints    []Endpoint     `json:"endpoints"`
+	Artifacts    ArtifactSearch `json:"artifacts"`
+	Sources      SourceRepo     `json:"sources"`
+	ImageSources SourceRepo     `json:"imageSources"`
+	JarAddrList  []string       `json:"jarAddrList"`
+	Pods         []Pod          `json:"pods"`
+	ErrorMessage string         `json:"errorMessage,omitempty"`
+	IsDelete     bool           `json:"isDelete"`
+	// what was extracted from each artifact by the last plan
+	Extractions []*extractor.Result `json:"extractions,omitempty"`
+	// classes whose exec data mismatches the extracted classes in the last report
+	ClassMismatches *ServiceClassMismatches `json:"classMismatches,omitempty"`
+
+	extractKeys map[string]string
+}
+
+// ServeAPI serves the control api and the prometheus metrics of the agent on addr until ctx is done
+func ServeAPI(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: newAPIHandler(),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("serve api on %v", addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("serve api error %v", err)
+	}
+	return nil
+}
+
+func newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/jobs", authorize(listJobsHandler))
+	mux.Handle("/api/jobs/", authorize(jobHandler))
+	mux.Handle("/api/services", authorize(listServicesHandler))
+	mux.Handle("/api/plan", authorize(planHandler))
+	mux.Handle("/metrics", metricsHandler())
+	return mux
+}
+
+// authorize serves the api to the clients with the api token, or only to the clients in the pod of the agent,
+// as through kubectl port-forward, when the token is not set. The metrics are served to all clients.
+func authorize(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+func authorized(r *http.Request) bool {
+	if conf.Cfg.ApiToken != "" {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+conf.Cfg.ApiToken)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	var jobs []JobView
+	withStateLock(func() {
+		RunJobs.Range(func(key, value interface{}) bool {
+			jobs = append(jobs, newJobView(value.(*DetectionJob)))
+			return true
+		})
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].PlanID < jobs[j].PlanID })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func listServicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	var services []ServiceView
+	withStateLock(func() {
+		Services.Range(func(key, value interface{}) bool {
+			svc := value.(*Service)
+			services = append(services, ServiceView{
+				Name:         svc.Name,
+				Image:        svc.Image,
+				Endpoints:    svc.Endpoints,
+				Artifacts:    svc.Artifacts,
+				Sources:      svc.Sources,
+				ImageSources: svc.ImageSources,
+				JarAddrList:  svc.JarAddrList,
+				extractKeys:  svc.ExtractKeys,
+				Pods:         append([]Pod{}, svc.Pods...),
+				ErrorMessage: svc.ErrorMessage,
+				IsDelete:     svc.IsDelete,
+			})
+			return true
+		})
+	})
+	for i := range services {
+		loadExtractions(services[i].JarAddrList, services[i].extractKeys)
+		services[i].Extractions = getExtractions(services[i].JarAddrList)
+		services[i].ClassMismatches = getClassMismatches(services[i].Name)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	writeJSON(w, http.StatusOK, services)
+}
+
+// jobHandler handles /api/jobs/{planID}/{action}
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/"), "/")
+	planID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid plan id %v", parts[0]))
+		return
+	}
+	job, ok := GetJob(planID)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("plan %v not found", planID))
+		return
+	}
+
+	var action string
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, jobView(job))
+	case action == "dump" && r.Method == http.MethodPost:
+		dumpExec(planID)
+		writeJSON(w, http.StatusOK, jobView(job))
+	case action == "merge" && r.Method == http.MethodPost:
+		_, svcExecMap, err := mergeProjectExec(planID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, svcExecMap)
+	case action == "report" && r.Method == http.MethodPost:
+		// the report is only sent to the center when asked, so that it can be checked before the plan ends
+		var projectReport *ProjectReport
+		if r.URL.Query().Get("callback") == "true" {
+			// the end callback is sent as success, so only a plan ending is reported to the center
+			if view := jobView(job); view.Status != EndingStatus {
+				writeError(w, http.StatusConflict, fmt.Errorf("plan %v is %v, only an ending plan is sent to the center", planID, view.Status))
+				return
+			}
+			err = reportAndCallback(planID)
+			projectReport = jobView(job).LatestReport
+		} else {
+			unlock := lockReport(planID)
+			projectReport, err = generateReport(planID)
+			unlock()
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, projectReport)
+	case action == "exec" && r.Method == http.MethodGet:
+		serveFile(w, r, jobView(job).LatestExec)
+	case action == "report" && r.Method == http.MethodGet:
+		latestReport := jobView(job).LatestReport
+		if latestReport == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("plan %v has no report yet", planID))
+			return
+		}
+		addr, err := latestReportAddr(latestReport, r.URL.Query().Get("service"), r.URL.Query().Get("format"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		serveFile(w, r, addr)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// planHandler replaces the plan file of the standalone center
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	standalone, ok := center.(*standaloneCenter)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("plan can only be set in standalone mode"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		detail, err := standalone.Status()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var detail CodeCoverageExecRecordDetail
+		if err := json.Unmarshal(body, &detail); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parse plan error %v", err))
+			return
+		}
+		if err := standalone.SetPlan(&detail); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &detail)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	}
+}
+
+// jobView returns the view of the job read under the state lock
+func jobView(job *DetectionJob) JobView {
+	var view JobView
+	withStateLock(func() {
+		view = newJobView(job)
+	})
+	return view
+}
+
+func newJobView(job *DetectionJob) JobView {
+	return JobView{
+		PlanID:        job.PlanID,
+		Status:        job.Status,
+		JobStatus:     job.JobStatus,
+		Includes:      job.Includes,
+		Excludes:      job.Excludes,
+		GitRepo:       job.GitRepo,
+		BaseRevision:  job.BaseRevision,
+		HeadRevision:  job.HeadRevision,
+		ReportFormats: job.ReportFormats,
+		ErrorMsg:      job.ErrorMsg,
+		LatestExec:    job.LatestExec,
+		LatestReport:  job.LatestReport,
+	}
+}
+
+// latestReportAddr returns the file of the report in format, html tar by default
+func latestReportAddr(projectReport *ProjectReport, service string, format string) (string, error) {
+	if service != "" {
+		for _, svcReport := range projectReport.Result.Services {
+			if svcReport.Name != service {
+				continue
+			}
+			switch format {
+			case "", "html":
+				return svcReport.HtmlTarAddr, nil
+			case "xml":
+				return svcReport.XmlTarAddr, nil
+			}
+			return "", fmt.Errorf("report format %v not generated for svc %v", format, service)
+		}
+		return "", fmt.Errorf("svc %v has no report", service)
+	}
+
+	switch format {
+	case "", "html":
+		return projectReport.HtmlTarAddr, nil
+	case "xml":
+		return projectReport.Result.XmlTarAddr, nil
+	}
+	addr, ok := projectReport.Result.FormatAddrs[format]
+	if !ok {
+		return "", fmt.Errorf("report format %v not generated", format)
+	}
+	return addr, nil
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, addr string) {
+	if addr == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("file not generated yet"))
+		return
+	}
+	if _, err := os.Stat(addr); err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("file %v not found", addr))
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(addr)))
+	http.ServeFile(w, r, addr)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("write api response error %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Code: http.StatusText(status), Msg: err.Error()})
+}
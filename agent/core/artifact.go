@@ -0,0 +1,169 @@
+package core
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractor"
+)
+
+// the artifacts of a service are jars, wars, exploded webapps and class dirs found under the search roots
+var (
+	defaultArtifactRoots    = []string{"/app"}
+	defaultArtifactPatterns = []string{"**/*.jar", "**/*.war", "**/WEB-INF/classes"}
+)
+
+// ArtifactSearch is where the artifacts of a service are searched in its containers
+type ArtifactSearch struct {
+	// dirs copied from the container
+	Roots []string `json:"roots"`
+	// globs of the artifact paths relative to a root, ** matches any dirs
+	Patterns []string `json:"patterns"`
+	// names of files and dirs not copied, as the huge trees that hold no artifacts
+	Excludes []string `json:"excludes"`
+}
+
+func (a ArtifactSearch) roots() []string {
+	if len(a.Roots) > 0 {
+		return a.Roots
+	}
+	return defaultArtifactRoots
+}
+
+func (a ArtifactSearch) patterns() []string {
+	if len(a.Patterns) > 0 {
+		return a.Patterns
+	}
+	return defaultArtifactPatterns
+}
+
+func (a ArtifactSearch) equal(other ArtifactSearch) bool {
+	return strings.Join(a.Roots, ",") == strings.Join(other.Roots, ",") &&
+		strings.Join(a.Patterns, ",") == strings.Join(other.Patterns, ",") &&
+		strings.Join(a.Excludes, ",") == strings.Join(other.Excludes, ",")
+}
+
+// findArtifacts returns the artifacts under the copy of a search root.
+// A matched WEB-INF/classes dir stands for its exploded webapp, which is returned instead,
+// and an app dir made of many jars, as a Quarkus quarkus-app dir, is returned as a whole.
+func findArtifacts(dir string, search ArtifactSearch) ([]string, error) {
+	if extractor.IsAppDir(dir) {
+		return []string{dir}, nil
+	}
+
+	var patterns []*regexp.Regexp
+	for _, pattern := range search.patterns() {
+		patterns = append(patterns, globToRegexp(pattern))
+	}
+
+	var artifacts []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return nil
+		}
+		if excluded(info.Name(), search.Excludes) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() && extractor.IsAppDir(p) {
+			artifacts = append(artifacts, p)
+			return filepath.SkipDir
+		}
+		for _, pattern := range patterns {
+			if !pattern.MatchString(rel) {
+				continue
+			}
+			if info.IsDir() && strings.HasSuffix(rel, "WEB-INF/classes") {
+				artifacts = append(artifacts, filepath.Dir(filepath.Dir(p)))
+				return filepath.SkipDir
+			}
+			artifacts = append(artifacts, p)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return nil
+	})
+	return withoutNested(dedupe(artifacts)), err
+}
+
+// withoutNested drops the artifacts inside an artifact dir, as the jars of WEB-INF/lib of an exploded webapp,
+// they are extracted with the dir
+func withoutNested(artifacts []string) []string {
+	var result []string
+	for _, artifact := range artifacts {
+		var nested bool
+		for _, dir := range artifacts {
+			if strings.HasPrefix(artifact, dir+string(filepath.Separator)) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, artifact)
+		}
+	}
+	return result
+}
+
+func excluded(name string, excludes []string) bool {
+	for _, exclude := range excludes {
+		if ok, _ := path.Match(exclude, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a glob into a regexp matching the whole path,
+// * and ? do not match /, and **/ matches any dirs
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func dedupe(list []string) []string {
+	var seen = map[string]bool{}
+	var result []string
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, c := range []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"**/*.jar", "app.jar", true},
+		{"**/*.jar", "lib/app.jar", true},
+		{"**/*.jar", "a/b/c/app.jar", true},
+		{"**/*.jar", "app.jar.bak", false},
+		{"*.jar", "app.jar", true},
+		{"*.jar", "lib/app.jar", false},
+		{"lib/*.jar", "lib/app.jar", true},
+		{"lib/*.jar", "lib/ext/app.jar", false},
+		{"lib/**", "lib/ext/app.jar", true},
+		{"lib/**/*.jar", "lib/app.jar", true},
+		{"?.jar", "a.jar", true},
+		{"?.jar", "ab.jar", false},
+		{"?.jar", "/.jar", false},
+		{"**/WEB-INF/classes", "WEB-INF/classes", true},
+		{"**/WEB-INF/classes", "webapps/ROOT/WEB-INF/classes", true},
+		{"**/WEB-INF/classes", "webapps/ROOT/WEB-INF/classes/com", false},
+		{"**/src/main/java", "order/api/src/main/java", true},
+		{"**/src/main/java", "src/main/java", true},
+		{"**/src/main/java", "src/main/javascript", false},
+		{"app.v1+.jar", "app.v1+.jar", true},
+		{"app.v1+.jar", "appxv11.jar", false},
+	} {
+		if got := globToRegexp(c.glob).MatchString(c.path); got != c.match {
+			t.Errorf("glob %q match %q = %v, want %v", c.glob, c.path, got, c.match)
+		}
+	}
+}
+
+func TestFindArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"lib/order.jar",
+		"lib/order.jar.bak",
+		"bin/admin.war",
+		"webapps/ROOT/WEB-INF/classes/com/example/Order.class",
+		"webapps/ROOT/WEB-INF/lib/dto.jar",
+		"webapps/ROOT/index.html",
+		"logs/old/app.jar",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		search ArtifactSearch
+		want   []string
+	}{
+		// an exploded webapp is found by its WEB-INF/classes, and its WEB-INF/lib is extracted with it
+		{ArtifactSearch{Excludes: []string{"logs"}}, []string{"bin/admin.war", "lib/order.jar", "webapps/ROOT"}},
+		{ArtifactSearch{}, []string{"bin/admin.war", "lib/order.jar", "logs/old/app.jar", "webapps/ROOT"}},
+		{ArtifactSearch{Patterns: []string{"lib/*.jar"}}, []string{"lib/order.jar"}},
+		{ArtifactSearch{Patterns: []string{"**/*.jar"}, Excludes: []string{"order*"}}, []string{"logs/old/app.jar", "webapps/ROOT/WEB-INF/lib/dto.jar"}},
+	} {
+		artifacts, err := findArtifacts(dir, c.search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, artifact := range artifacts {
+			rel, _ := filepath.Rel(dir, artifact)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("artifacts of %+v = %v, want %v", c.search, got, c.want)
+		}
+	}
+}
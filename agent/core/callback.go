@@ -1,28 +1,50 @@
 package core
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"time"
 
 	"github.com/google/martian/log"
 
-	"github.com/erda-project/erda-sourcecov/agent/conf"
-	"github.com/erda-project/erda-sourcecov/agent/pkg/httpclient"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/coverage"
 )
 
 type CallbackEndRequest struct {
-	ID        uint64
-	Status    string
-	Msg       string
-	ReportXml string `json:"reportXmlUUID"`
+	ID              uint64
+	Status          string
+	Msg             string
+	ReportXml       string                `json:"reportXmlUUID"`
+	ReportCobertura string                `json:"reportCoberturaUUID,omitempty"`
+	ReportLcov      string                `json:"reportLcovUUID,omitempty"`
+	ReportSonar     string                `json:"reportSonarUUID,omitempty"`
+	Summary         *coverage.Summary     `json:"summary,omitempty"`
+	DiffCoverage    *coverage.DiffSummary `json:"diffCoverage,omitempty"`
+	// classes whose exec data is of another build than the classes of the service
+	ClassMismatches []*ServiceClassMismatches `json:"classMismatches,omitempty"`
+	// the xml report of each service against its own classes
+	Services []ServiceReportFile `json:"services,omitempty"`
 }
 
-func callbackEnd(planID uint64, msg string, status CodeCoverageExecStatus, reportXmlAddr string) error {
+// ServiceReportFile is an uploaded report of a service
+type ServiceReportFile struct {
+	Name      string `json:"name"`
+	ReportXml string `json:"reportXmlUUID,omitempty"`
+	ReportTar string `json:"reportTarUrl,omitempty"`
+}
+
+// ReportResult is the output of the project report sent by the end callback,
+// FormatAddrs holds the report file of each format picked by the plan
+type ReportResult struct {
+	XmlTarAddr      string
+	FormatAddrs     map[string]string
+	Summary         *coverage.Summary
+	DiffCoverage    *coverage.DiffSummary
+	ClassMismatches []*ServiceClassMismatches
+	Services        []*ServiceReport
+}
+
+func callbackEnd(planID uint64, msg string, status CodeCoverageExecStatus, result *ReportResult) error {
 	log.Infof("callbackEnd planID %v status %v \n", planID, status)
 
 	var req = CallbackEndRequest{
@@ -30,124 +52,86 @@ func callbackEnd(planID uint64, msg string, status CodeCoverageExecStatus, repor
 		Status: string(status),
 		Msg:    msg,
 	}
-	if reportXmlAddr != "" {
-		file, err := os.Open(reportXmlAddr)
-		if err != nil {
-			return fmt.Errorf("upload xml error %v", err)
-		}
-		fileData, err := uploadFile(conf.Cfg.CenterHost, conf.Cfg.CenterToken, planID, conf.Cfg.ProjectID, file)
-		//fileData, err := uploadFile("https://erda.dev.terminus.io", conf.Cfg.CenterToken, planID, conf.Cfg.ProjectID, file)
+	if result != nil && result.XmlTarAddr != "" {
+		fileData, err := uploadReportFile(planID, result.XmlTarAddr)
 		if err != nil {
 			return fmt.Errorf("upload xml error %v", err)
 		}
 		req.ReportXml = fileData.UUID
 	}
-
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("json marshal req error %v", err)
-	}
-	reqBodyReader := bytes.NewReader(reqBody)
-
-	request, err := http.NewRequest("POST", fmt.Sprintf("%v/api/code-coverage/actions/end-callBack", conf.Cfg.CenterHost), reqBodyReader)
-	if err != nil {
-		return fmt.Errorf("new request error %v", err)
-	}
-	defer func() {
-		if request != nil && request.Body != nil {
-			request.Body.Close()
+	if result != nil {
+		for format, addr := range result.FormatAddrs {
+			fileData, err := uploadReportFile(planID, addr)
+			if err != nil {
+				return fmt.Errorf("upload %v report error %v", format, err)
+			}
+			switch format {
+			case ReportFormatCobertura:
+				req.ReportCobertura = fileData.UUID
+			case ReportFormatLcov:
+				req.ReportLcov = fileData.UUID
+			case ReportFormatSonar:
+				req.ReportSonar = fileData.UUID
+			}
+		}
+		req.Summary = result.Summary
+		req.DiffCoverage = result.DiffCoverage
+		req.ClassMismatches = result.ClassMismatches
+		for _, svcReport := range result.Services {
+			fileData, err := uploadReportFile(planID, svcReport.XmlTarAddr)
+			if err != nil {
+				return fmt.Errorf("upload svc %v xml error %v", svcReport.Name, err)
+			}
+			req.Services = append(req.Services, ServiceReportFile{Name: svcReport.Name, ReportXml: fileData.UUID})
 		}
-	}()
-	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-	request.Header.Set("Authorization", conf.Cfg.CenterToken)
-	request.Header.Set("Org", conf.Cfg.OrgName)
-	request.Header.Set("USER-ID", "2")
-
-	client := http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return fmt.Errorf("do client error %v", err)
-	}
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read response body error %v", err)
-	}
-	str := string(respBytes)
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("response status code not 200, body: %v", str)
 	}
-	log.Infof("response body: %v", str)
-	return nil
+
+	err := center.CallbackEnd(&req)
+	observeCallback(callbackKindEnd, err)
+	setPendingCallback(pendingCallback{Kind: callbackKindEnd, PlanID: planID, End: &req}, err)
+	return err
 }
 
-type callbackReportRequest struct {
+type CallbackReportRequest struct {
 	ID        uint64
 	Status    string
 	Msg       string
 	ReportTar string `json:"reportTarUrl"`
+	// the html report of each service against its own classes
+	Services []ServiceReportFile `json:"services,omitempty"`
 }
 
-func callbackReport(planID uint64, status CodeCoverageExecStatus, msg string, reportAddr string) error {
+func callbackReport(planID uint64, status CodeCoverageExecStatus, msg string, reportAddr string, svcReports []*ServiceReport) error {
 	log.Infof("callbackReport planID %v status %v \n", planID, status)
 
-	var req = callbackReportRequest{
+	var req = CallbackReportRequest{
 		ID:     planID,
 		Status: string(status),
 		Msg:    msg,
 	}
 	if reportAddr != "" {
-		file, err := os.Open(reportAddr)
-		if err != nil {
-			return fmt.Errorf("upload html error %v", err)
-		}
-
-		fileData, err := uploadFile(conf.Cfg.CenterHost, conf.Cfg.CenterToken, planID, conf.Cfg.ProjectID, file)
-		//fileData, err := uploadFile("https://erda.dev.terminus.io", conf.Cfg.CenterToken, planID, conf.Cfg.ProjectID, file)
+		fileData, err := uploadReportFile(planID, reportAddr)
 		if err != nil {
 			return fmt.Errorf("upload html error %v", err)
 		}
 
 		req.ReportTar = fileData.DownloadURL
 	}
-
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("json marshal req error %v", err)
-	}
-	reqBodyReader := bytes.NewReader(reqBody)
-
-	request, err := http.NewRequest("POST", fmt.Sprintf("%v/api/code-coverage/actions/report-callBack", conf.Cfg.CenterHost), reqBodyReader)
-	if err != nil {
-		return fmt.Errorf("new request error %v", err)
-	}
-	defer func() {
-		if request != nil && request.Body != nil {
-			request.Body.Close()
+	for _, svcReport := range svcReports {
+		fileData, err := uploadReportFile(planID, svcReport.HtmlTarAddr)
+		if err != nil {
+			return fmt.Errorf("upload svc %v html error %v", svcReport.Name, err)
 		}
-	}()
-	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-	request.Header.Set("Authorization", conf.Cfg.CenterToken)
-	request.Header.Set("Org", conf.Cfg.OrgName)
-	request.Header.Set("USER-ID", "2")
-
-	client := http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return fmt.Errorf("do client error %v", err)
+		req.Services = append(req.Services, ServiceReportFile{Name: svcReport.Name, ReportTar: fileData.DownloadURL})
 	}
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read response body error %v", err)
-	}
-	str := string(respBytes)
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("response status code not 200, body: %v", str)
-	}
-	log.Infof("response body: %v", str)
-	return nil
+
+	err := center.CallbackReport(&req)
+	observeCallback(callbackKindReport, err)
+	setPendingCallback(pendingCallback{Kind: callbackKindReport, PlanID: planID, Report: &req}, err)
+	return err
 }
 
-type callbackRequest struct {
+type CallbackRequest struct {
 	ID     uint64 `json:"id"`
 	Status string
 	Msg    string
@@ -164,63 +148,16 @@ func callbackReady(planID uint64, msg string) error {
 		return nil
 	}
 
-	var req = callbackRequest{
+	var req = CallbackRequest{
 		ID:     planID,
 		Status: string(ReadyStatus),
 		Msg:    msg,
 	}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("json marshal req error %v", err)
-	}
-	reqBodyReader := bytes.NewReader(reqBody)
-
-	request, err := http.NewRequest("POST", fmt.Sprintf("%v/api/code-coverage/actions/ready-callBack", conf.Cfg.CenterHost), reqBodyReader)
-	if err != nil {
-		return fmt.Errorf("new request error %v", err)
-	}
-	defer func() {
-		if request != nil && request.Body != nil {
-			request.Body.Close()
-		}
-	}()
-	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-	request.Header.Set("Authorization", conf.Cfg.CenterToken)
-	request.Header.Set("Org", conf.Cfg.OrgName)
-	request.Header.Set("USER-ID", "2")
-
-	client := http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return fmt.Errorf("do client error %v", err)
-	}
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read response body error %v", err)
-	}
-	str := string(respBytes)
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("response status code not 200, body: %v", str)
-	}
-	log.Infof("response body: %v", str)
-	return nil
-}
-
-type ErrorResponse struct {
-	Code string      `json:"code"`
-	Msg  string      `json:"msg"`
-	Ctx  interface{} `json:"ctx"`
-}
-
-type Header struct {
-	Success bool          `json:"success" `
-	Error   ErrorResponse `json:"err"`
-}
-
-type CodeCoverageExecRecordDetailResp struct {
-	Header
-	Data *CodeCoverageExecRecordDetail `json:"data"`
+	err := center.CallbackReady(&req)
+	observeCallback(callbackKindReady, err)
+	setPendingCallback(pendingCallback{Kind: callbackKindReady, PlanID: planID, Ready: &req}, err)
+	return err
 }
 
 type CodeCoverageExecRecordDetail struct {
@@ -230,54 +167,17 @@ type CodeCoverageExecRecordDetail struct {
 	MavenSetting string                 `json:"mavenSetting"`
 	Includes     string                 `json:"includes"`
 	Excludes     string                 `json:"excludes"`
+	// the diff cover is computed with Diff, or with the diff between BaseRevision and HeadRevision of GitRepo
+	GitRepo      string `json:"gitRepo"`
+	BaseRevision string `json:"baseRevision"`
+	HeadRevision string `json:"headRevision"`
+	Diff         string `json:"diff"`
+	// comma separated formats besides the jacoco xml and html report: cobertura, lcov, sonar
+	ReportFormats string `json:"reportFormats"`
 }
 
 func status() (*CodeCoverageExecRecordDetail, error) {
-	log.Infof("get projectID %v cover status", conf.Cfg.ProjectID)
-
-	request, err := http.NewRequest("GET", fmt.Sprintf("%v/api/code-coverage/actions/status?projectID=%v&workspace=%v", conf.Cfg.CenterHost, conf.Cfg.ProjectID, conf.Cfg.Workspace), nil)
-	if err != nil {
-		return nil, fmt.Errorf("new request error %v", err)
-	}
-	defer func() {
-		if request != nil && request.Body != nil {
-			request.Body.Close()
-		}
-	}()
-	request.Header.Set("Authorization", conf.Cfg.CenterToken)
-	request.Header.Set("Org", conf.Cfg.OrgName)
-	request.Header.Set("USER-ID", "2")
-
-	client := http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, fmt.Errorf("do client error %v", err)
-	}
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response body error %v", err)
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("response status code not 200, body: %v", string(respBytes))
-	}
-
-	log.Infof("response body: %v", string(respBytes))
-
-	var detail CodeCoverageExecRecordDetailResp
-	err = json.Unmarshal(respBytes, &detail)
-	if err != nil {
-		return nil, err
-	}
-	if !detail.Success {
-		return nil, fmt.Errorf("response not success")
-	}
-	return detail.Data, nil
-}
-
-// FileUploadResponse 文件上传响应
-type FileUploadResponse struct {
-	Header
-	Data *File `json:"data"`
+	return center.Status()
 }
 
 type File struct {
@@ -294,30 +194,11 @@ type File struct {
 	ExpiredAt   *time.Time `json:"expiredAt,omitempty"`
 }
 
-func uploadFile(erdaAddr string, token string, planID uint64, projectID uint64, file *os.File) (*File, error) {
-	var uploadResp FileUploadResponse
-
-	multiparts := map[string]httpclient.MultipartItem{
-		"file": {
-			Reader:   file,
-			Filename: file.Name(),
-		},
-	}
-
-	resp, err := httpclient.New(httpclient.WithCompleteRedirect(), httpclient.WithTimeout(3*time.Minute, 3*time.Minute)).
-		Post(erdaAddr).
-		Path("/api/files").
-		Param("fileFrom", fmt.Sprintf("jacoco-upload-%d-%d", planID, projectID)).
-		Param("expiredIn", "4320h").
-		Header("Authorization", token).
-		MultipartFormDataBody(multiparts).
-		Do().JSON(&uploadResp)
+func uploadReportFile(planID uint64, addr string) (*File, error) {
+	file, err := os.Open(addr)
 	if err != nil {
 		return nil, err
 	}
-	if !resp.IsOK() || !uploadResp.Success {
-		return nil, fmt.Errorf("statusCode: %d, respError: %s", resp.StatusCode(), uploadResp.Error)
-	}
-
-	return uploadResp.Data, nil
+	defer file.Close()
+	return center.UploadFile(planID, file)
 }
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/erda-project/erda-sourcecov/agent/conf"
+)
+
+// Center is the coverage center the agent gets plans from and sends the callbacks and reports to
+type Center interface {
+	// Status returns the current plan of the project, nil if there is none
+	Status() (*CodeCoverageExecRecordDetail, error)
+	CallbackReady(req *CallbackRequest) error
+	CallbackEnd(req *CallbackEndRequest) error
+	CallbackReport(req *CallbackReportRequest) error
+	// UploadFile stores a report file, the returned File is referenced in the callbacks
+	UploadFile(planID uint64, file *os.File) (*File, error)
+}
+
+var center Center
+
+// InitCenter creates the center of conf.Cfg.CenterMode
+func InitCenter() error {
+	switch conf.Cfg.CenterMode {
+	case conf.CenterModeErda:
+		center = newErdaCenter()
+	case conf.CenterModeStandalone:
+		standalone, err := newStandaloneCenter(conf.Cfg.StandalonePlanFile, GenStandaloneReportDir())
+		if err != nil {
+			return err
+		}
+		center = standalone
+	default:
+		return fmt.Errorf("unknown center mode %v", conf.Cfg.CenterMode)
+	}
+	return nil
+}
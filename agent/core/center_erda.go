@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/martian/log"
+
+	"github.com/erda-project/erda-sourcecov/agent/conf"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/httpclient"
+)
+
+type ErrorResponse struct {
+	Code string      `json:"code"`
+	Msg  string      `json:"msg"`
+	Ctx  interface{} `json:"ctx"`
+}
+
+type Header struct {
+	Success bool          `json:"success" `
+	Error   ErrorResponse `json:"err"`
+}
+
+type CodeCoverageExecRecordDetailResp struct {
+	Header
+	Data *CodeCoverageExecRecordDetail `json:"data"`
+}
+
+// FileUploadResponse 文件上传响应
+type FileUploadResponse struct {
+	Header
+	Data *File `json:"data"`
+}
+
+// erdaCenter is the code coverage center of erda
+type erdaCenter struct {
+	host      string
+	token     string
+	orgName   string
+	projectID uint64
+	workspace string
+}
+
+func newErdaCenter() *erdaCenter {
+	return &erdaCenter{
+		host:      conf.Cfg.CenterHost,
+		token:     conf.Cfg.CenterToken,
+		orgName:   conf.Cfg.OrgName,
+		projectID: conf.Cfg.ProjectID,
+		workspace: conf.Cfg.Workspace,
+	}
+}
+
+func (e *erdaCenter) Status() (*CodeCoverageExecRecordDetail, error) {
+	log.Infof("get projectID %v cover status", e.projectID)
+
+	request, err := http.NewRequest("GET", fmt.Sprintf("%v/api/code-coverage/actions/status?projectID=%v&workspace=%v", e.host, e.projectID, e.workspace), nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request error %v", err)
+	}
+	defer func() {
+		if request != nil && request.Body != nil {
+			request.Body.Close()
+		}
+	}()
+	request.Header.Set("Authorization", e.token)
+	request.Header.Set("Org", e.orgName)
+	request.Header.Set("USER-ID", "2")
+
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("do client error %v", err)
+	}
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body error %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("response status code not 200, body: %v", string(respBytes))
+	}
+
+	log.Infof("response body: %v", string(respBytes))
+
+	var detail CodeCoverageExecRecordDetailResp
+	err = json.Unmarshal(respBytes, &detail)
+	if err != nil {
+		return nil, err
+	}
+	if !detail.Success {
+		return nil, fmt.Errorf("response not success")
+	}
+	return detail.Data, nil
+}
+
+func (e *erdaCenter) CallbackReady(req *CallbackRequest) error {
+	return e.post("/api/code-coverage/actions/ready-callBack", req)
+}
+
+func (e *erdaCenter) CallbackEnd(req *CallbackEndRequest) error {
+	return e.post("/api/code-coverage/actions/end-callBack", req)
+}
+
+func (e *erdaCenter) CallbackReport(req *CallbackReportRequest) error {
+	return e.post("/api/code-coverage/actions/report-callBack", req)
+}
+
+func (e *erdaCenter) post(path string, req interface{}) error {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("json marshal req error %v", err)
+	}
+	reqBodyReader := bytes.NewReader(reqBody)
+
+	request, err := http.NewRequest("POST", e.host+path, reqBodyReader)
+	if err != nil {
+		return fmt.Errorf("new request error %v", err)
+	}
+	defer func() {
+		if request != nil && request.Body != nil {
+			request.Body.Close()
+		}
+	}()
+	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	request.Header.Set("Authorization", e.token)
+	request.Header.Set("Org", e.orgName)
+	request.Header.Set("USER-ID", "2")
+
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("do client error %v", err)
+	}
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response body error %v", err)
+	}
+	str := string(respBytes)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("response status code not 200, body: %v", str)
+	}
+	log.Infof("response body: %v", str)
+	return nil
+}
+
+func (e *erdaCenter) UploadFile(planID uint64, file *os.File) (*File, error) {
+	var uploadResp FileUploadResponse
+
+	multiparts := map[string]httpclient.MultipartItem{
+		"file": {
+			Reader:   file,
+			Filename: file.Name(),
+		},
+	}
+
+	resp, err := httpclient.New(httpclient.WithCompleteRedirect(), httpclient.WithTimeout(3*time.Minute, 3*time.Minute)).
+		Post(e.host).
+		Path("/api/files").
+		Param("fileFrom", fmt.Sprintf("jacoco-upload-%d-%d", planID, e.projectID)).
+		Param("expiredIn", "4320h").
+		Header("Authorization", e.token).
+		MultipartFormDataBody(multiparts).
+		Do().JSON(&uploadResp)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsOK() || !uploadResp.Success {
+		return nil, fmt.Errorf("statusCode: %d, respError: %s", resp.StatusCode(), uploadResp.Error)
+	}
+
+	return uploadResp.Data, nil
+}
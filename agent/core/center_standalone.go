@@ -0,0 +1,161 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/martian/log"
+)
+
+// standaloneCenter runs the agent without erda, the plan is read from a local json file,
+// callbacks and uploaded reports are written into the report dir on the agent volume
+type standaloneCenter struct {
+	planFile  string
+	reportDir string
+
+	lock sync.Mutex
+	// status of each plan reported by the callbacks
+	statuses map[uint64]CodeCoverageExecStatus
+}
+
+func newStandaloneCenter(planFile string, reportDir string) (*standaloneCenter, error) {
+	err := os.MkdirAll(reportDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create standalone report dir error %v", err)
+	}
+	return &standaloneCenter{
+		planFile:  planFile,
+		reportDir: reportDir,
+		statuses:  map[uint64]CodeCoverageExecStatus{},
+	}, nil
+}
+
+// Status returns the plan of the plan file, with the status moved forward by the callbacks:
+// ready after the ready callback until the plan is ended, and the end status after the end callback
+func (s *standaloneCenter) Status() (*CodeCoverageExecRecordDetail, error) {
+	data, err := ioutil.ReadFile(s.planFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read plan file error %v", err)
+	}
+
+	var detail CodeCoverageExecRecordDetail
+	err = json.Unmarshal(data, &detail)
+	if err != nil {
+		return nil, fmt.Errorf("parse plan file %v error %v", s.planFile, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch s.statuses[detail.PlanID] {
+	case SuccessStatus, FailStatus:
+		detail.Status = s.statuses[detail.PlanID]
+	case ReadyStatus:
+		if detail.Status == RunningStatus {
+			detail.Status = ReadyStatus
+		}
+	}
+	return &detail, nil
+}
+
+// SetPlan replaces the plan file, a new plan id starts a new plan
+func (s *standaloneCenter) SetPlan(detail *CodeCoverageExecRecordDetail) error {
+	data, err := json.MarshalIndent(detail, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal plan error %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(s.planFile), 0755)
+	if err != nil {
+		return fmt.Errorf("create plan file dir error %v", err)
+	}
+	err = ioutil.WriteFile(s.planFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("write plan file error %v", err)
+	}
+
+	s.lock.Lock()
+	delete(s.statuses, detail.PlanID)
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *standaloneCenter) CallbackReady(req *CallbackRequest) error {
+	return s.callback(req.ID, CodeCoverageExecStatus(req.Status), "ready.json", req)
+}
+
+func (s *standaloneCenter) CallbackEnd(req *CallbackEndRequest) error {
+	return s.callback(req.ID, CodeCoverageExecStatus(req.Status), "end.json", req)
+}
+
+func (s *standaloneCenter) CallbackReport(req *CallbackReportRequest) error {
+	return s.callback(req.ID, "", "report.json", req)
+}
+
+func (s *standaloneCenter) callback(planID uint64, status CodeCoverageExecStatus, fileName string, req interface{}) error {
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal req error %v", err)
+	}
+	dir, err := s.planReportDir(planID)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, fileName), data, 0644)
+	if err != nil {
+		return fmt.Errorf("write callback %v error %v", fileName, err)
+	}
+	log.Infof("plan %v callback written to %v", planID, filepath.Join(dir, fileName))
+
+	if status != "" {
+		s.lock.Lock()
+		s.statuses[planID] = status
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+// UploadFile copies the file into the report dir of the plan
+func (s *standaloneCenter) UploadFile(planID uint64, file *os.File) (*File, error) {
+	dir, err := s.planReportDir(planID)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(file.Name())
+	dest, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer dest.Close()
+	size, err := io.Copy(dest, file)
+	if err != nil {
+		return nil, fmt.Errorf("copy report file %v error %v", name, err)
+	}
+
+	now := time.Now()
+	return &File{
+		UUID:        name,
+		DisplayName: name,
+		ByteSize:    size,
+		DownloadURL: dest.Name(),
+		From:        "standalone",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+func (s *standaloneCenter) planReportDir(planID uint64) (string, error) {
+	dir := filepath.Join(s.reportDir, fmt.Sprint(planID))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("create plan report dir error %v", err)
+	}
+	return dir, nil
+}
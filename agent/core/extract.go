@@ -0,0 +1,352 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/log"
+
+	"github.com/erda-project/erda-sourcecov/agent/conf"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractcache"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractor"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/jacoco"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/maven"
+)
+
+// bumped when the extracted layout changes, so older cache entries are not used
+const extractCacheVersion = "5"
+
+const (
+	extractResultFile   = "result.json"
+	extractClassIDsFile = "class-ids.json"
+)
+
+var (
+	// results of the extractions by artifact path
+	extractions = sync.Map{}
+	// jacoco ids of the classes extracted by artifact path
+	extractionClassIDs = sync.Map{}
+
+	classCache     *extractcache.Cache
+	classCacheErr  error
+	classCacheOnce sync.Once
+)
+
+func getClassCache() (*extractcache.Cache, error) {
+	classCacheOnce.Do(func() {
+		classCache, classCacheErr = extractcache.New(GenExtractCacheDir(), conf.Cfg.ExtractCacheSizeMB<<20)
+	})
+	return classCache, classCacheErr
+}
+
+// buildClassTrees extracts the classes and sources of the artifacts of each service into its class dir,
+// and adds the sources of its repository. Each service is reported against its own tree.
+// The jars of a service are not reloaded while they are extracted.
+func buildClassTrees(svcNames []string, svcSources map[string]SourceRepo, job *DetectionJob) error {
+	var names = append([]string{}, svcNames...)
+	sort.Strings(names)
+
+	var sourceRepos = map[string]SourceRepo{}
+	for _, name := range names {
+		err := extractServiceClassSources(name, job)
+		if err != nil {
+			return fmt.Errorf("svc %v %v", name, err)
+		}
+		if repo, ok := svcSources[name]; ok {
+			sourceRepos[filepath.Join(GenSvcClassDir(name), "sub", "libjarsrc")] = repo
+		}
+	}
+	// the reports are still generated without the sources of the applications
+	if err := checkoutSources(sourceRepos, job.Includes, job.Excludes); err != nil {
+		log.Errorf("plan %v checkout application sources error %v", job.PlanID, err)
+	}
+
+	// the classes of the services are kept apart, a class of the same name in two services is another class
+	if err := os.RemoveAll(GenProjectClassDir()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// extractServiceClassSources extracts the current jars of a service into its class dir under the jar lock of the service
+func extractServiceClassSources(name string, job *DetectionJob) error {
+	defer lockServiceJars(name)()
+
+	svc, ok := GetService(name)
+	if !ok {
+		return fmt.Errorf("not found")
+	}
+	var artifacts []string
+	withStateLock(func() {
+		artifacts = svc.JarAddrList
+	})
+	keys, err := extractClassSources(artifacts, job.Includes, job.Excludes, job.MavenSettings, GenSvcClassDir(name))
+	if err != nil {
+		return err
+	}
+	// kept in the state, so the extractions are found in the cache after a restart
+	withStateLock(func() {
+		svc.ExtractKeys = keys
+	})
+	SetService(name, svc)
+	return nil
+}
+
+// extractClassSources extracts the classes and sources of the artifacts with the plan includes, excludes and maven settings
+// into destDir/sub/libjarcls and destDir/sub/libjarsrc.
+// Each artifact is extracted once for its content, filters and settings, and then taken from the cache.
+// It returns the cache key of each artifact.
+func extractClassSources(artifacts []string, includes string, excludes string, mavenSettings string, destDir string) (map[string]string, error) {
+	cache, err := getClassCache()
+	if err != nil {
+		return nil, fmt.Errorf("open extract cache error %v", err)
+	}
+	settings, err := maven.ParseSettings([]byte(mavenSettings))
+	if err != nil {
+		return nil, fmt.Errorf("parse maven settings error %v", err)
+	}
+	sources := &mavenSources{resolver: maven.NewResolver(settings, GenMavenRepoDir())}
+
+	classDir := filepath.Join(destDir, "sub", "libjarcls")
+	sourceDir := filepath.Join(destDir, "sub", "libjarsrc")
+	if err := os.RemoveAll(filepath.Join(destDir, "sub")); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{classDir, sourceDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys = map[string]string{}
+	for _, artifact := range artifacts {
+		key, err := artifactKey(artifact, includes, excludes, mavenSettings)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, release, hit, err := cache.Get(key, func(dir string) error {
+			return extractArtifact(artifact, includes, excludes, sources, dir)
+		})
+		observeExtractCache(hit, err)
+		if err != nil {
+			return nil, fmt.Errorf("extract artifact %v error %v", artifact, err)
+		}
+		log.Infof("artifact %v extracted, cache hit %v", artifact, hit)
+		setExtraction(artifact, entry)
+		keys[artifact] = key
+
+		err = extractcache.LinkTree(filepath.Join(entry, "libjarcls"), classDir)
+		if err == nil {
+			err = extractcache.LinkTree(filepath.Join(entry, "libjarsrc"), sourceDir)
+		}
+		release()
+		if err != nil {
+			return nil, fmt.Errorf("link artifact %v classes error %v", artifact, err)
+		}
+	}
+	extractCacheBytes.Set(float64(cache.Size()))
+	return keys, nil
+}
+
+// artifactKey returns the extract cache key of an artifact, with the digests of the files besides it the extraction reads,
+// so a thin jar is extracted again when only its libraries change
+func artifactKey(artifact string, includes string, excludes string, mavenSettings string) (string, error) {
+	digest, err := extractcache.Digest(artifact)
+	if err != nil {
+		return "", fmt.Errorf("digest artifact %v error %v", artifact, err)
+	}
+	parts := []string{extractCacheVersion, digest, includes, excludes, mavenSettings}
+
+	inputs, err := extractor.New(extractor.Options{}).Inputs(artifact)
+	if err != nil {
+		return "", fmt.Errorf("read artifact %v error %v", artifact, err)
+	}
+	for _, input := range inputs {
+		inputDigest, err := extractcache.Digest(input)
+		if err != nil {
+			return "", fmt.Errorf("digest artifact %v input %v error %v", artifact, input, err)
+		}
+		parts = append(parts, filepath.Base(input), inputDigest)
+	}
+	return extractcache.Key(parts...), nil
+}
+
+// extractArtifact extracts the classes and sources of one artifact into dir, with the result of the extraction
+func extractArtifact(artifact string, includes string, excludes string, sources extractor.SourceResolver, dir string) error {
+	e := extractor.New(extractor.Options{
+		Includes: includes,
+		Excludes: excludes,
+		Sources:  sources,
+	})
+	classDir, sourceDir := filepath.Join(dir, "libjarcls"), filepath.Join(dir, "libjarsrc")
+	for _, d := range []string{classDir, sourceDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	result, err := e.Extract(artifact, classDir, sourceDir)
+	if err != nil {
+		return err
+	}
+	if result.SourceError != "" {
+		log.Errorf("get sources of artifact %v libraries error %v", artifact, result.SourceError)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, extractResultFile), data, 0644); err != nil {
+		return err
+	}
+	return writeClassIDs(classDir, filepath.Join(dir, extractClassIDsFile))
+}
+
+// writeClassIDs writes the jacoco ids of the class files of classDir by vm class name
+func writeClassIDs(classDir string, file string) error {
+	var ids = map[string]uint64{}
+	err := filepath.Walk(classDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || !strings.HasSuffix(p, ".class") {
+			return err
+		}
+		rel, err := filepath.Rel(classDir, p)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		ids[strings.TrimSuffix(filepath.ToSlash(rel), ".class")] = jacoco.ClassID(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// mavenSources resolves the sources jars of the libraries taken by their coordinates
+type mavenSources struct {
+	resolver *maven.Resolver
+}
+
+func (s *mavenSources) Resolve(libs []extractor.Library) (map[string]string, error) {
+	var sources = map[string]string{}
+	var errs []string
+	for _, lib := range libs {
+		if !lib.Taken || lib.GroupID == "" || lib.ArtifactID == "" || lib.Version == "" {
+			continue
+		}
+		p, err := s.resolver.Resolve(maven.Artifact{
+			GroupID:    lib.GroupID,
+			ArtifactID: lib.ArtifactID,
+			Version:    lib.Version,
+			Classifier: "sources",
+		})
+		if err == maven.ErrNotFound {
+			log.Debugf("sources of library %v not found", lib.File)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		sources[lib.File] = p
+	}
+	if len(errs) > 0 {
+		return sources, fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return sources, nil
+}
+
+// setExtraction keeps the result of the extraction of an artifact cached in dir for the api
+func setExtraction(artifact string, dir string) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, extractResultFile))
+	if err != nil {
+		return
+	}
+	var result extractor.Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return
+	}
+	result.Artifact = artifact
+	extractions.Store(artifact, &result)
+
+	var ids map[string]uint64
+	data, err = ioutil.ReadFile(filepath.Join(dir, extractClassIDsFile))
+	if err == nil && json.Unmarshal(data, &ids) == nil {
+		extractionClassIDs.Store(artifact, ids)
+	}
+}
+
+// loadExtractions loads the extractions of the artifacts not known since the start of the agent from the extract cache
+// by their keys, as after a restart that resumed a plan without extracting again.
+// It returns the artifacts whose extraction is not found.
+func loadExtractions(artifacts []string, keys map[string]string) []string {
+	var missing []string
+	for _, artifact := range artifacts {
+		if _, ok := extractionClassIDs.Load(artifact); ok {
+			continue
+		}
+		if !loadExtraction(artifact, keys[artifact]) {
+			missing = append(missing, artifact)
+		}
+	}
+	return missing
+}
+
+func loadExtraction(artifact string, key string) bool {
+	if key == "" {
+		return false
+	}
+	cache, err := getClassCache()
+	if err != nil {
+		return false
+	}
+	dir, release, ok := cache.Lookup(key)
+	if !ok {
+		return false
+	}
+	defer release()
+	setExtraction(artifact, dir)
+	_, ok = extractionClassIDs.Load(artifact)
+	return ok
+}
+
+// getClassIDs returns the jacoco ids of the classes extracted from the artifacts by vm class name,
+// a class may have many ids when it is in many artifacts
+func getClassIDs(artifacts []string) map[string][]uint64 {
+	var ids = map[string][]uint64{}
+	for _, artifact := range artifacts {
+		value, ok := extractionClassIDs.Load(artifact)
+		if !ok {
+			continue
+		}
+		for name, id := range value.(map[string]uint64) {
+			ids[name] = append(ids[name], id)
+		}
+	}
+	return ids
+}
+
+// getExtractions returns the results of the extractions of the artifacts
+func getExtractions(artifacts []string) []*extractor.Result {
+	var results []*extractor.Result
+	for _, artifact := range artifacts {
+		if value, ok := extractions.Load(artifact); ok {
+			results = append(results, value.(*extractor.Result))
+		}
+	}
+	return results
+}
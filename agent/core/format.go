@@ -0,0 +1,142 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/coverage"
+)
+
+// report formats generated besides the jacoco xml and html report
+const (
+	ReportFormatCobertura = "cobertura"
+	ReportFormatLcov      = "lcov"
+	ReportFormatSonar     = "sonar"
+)
+
+// used when the plan does not pick the formats
+var defaultReportFormats = []string{ReportFormatCobertura, ReportFormatLcov}
+
+type reportFormat struct {
+	fileName string
+	write    func(w io.Writer, projectReport *coverage.Report) error
+}
+
+var reportFormats = map[string]reportFormat{
+	ReportFormatCobertura: {
+		fileName: "_project_cobertura.xml",
+		write: func(w io.Writer, projectReport *coverage.Report) error {
+			// the sources of each service are in its own dir
+			return coverage.WriteCobertura(w, projectReport, svcSourceDir)
+		},
+	},
+	ReportFormatLcov: {
+		fileName: "_project_lcov.info",
+		write: func(w io.Writer, projectReport *coverage.Report) error {
+			// the records of each service point to the sources of the service
+			for _, group := range projectReport.Groups {
+				svcReport, _ := projectReport.Group(group.Name)
+				if err := coverage.WriteLcov(w, svcReport, svcSourceDir(group.Name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	ReportFormatSonar: {
+		fileName: "_project_sonar.tar.gz",
+		write:    writeSonarArchive,
+	},
+}
+
+// writeSonarArchive writes a tar.gz of the sonar report of each service named <service>.xml,
+// the paths of each report are relative to the source roots of its service
+func writeSonarArchive(w io.Writer, projectReport *coverage.Report) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, group := range projectReport.Groups {
+		svcReport, _ := projectReport.Group(group.Name)
+		var buf bytes.Buffer
+		if err := coverage.WriteSonar(&buf, svcReport); err != nil {
+			return err
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:    group.Name + ".xml",
+			Mode:    0644,
+			Size:    int64(buf.Len()),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// svcSourceDir is the dir of the sources of a service, the project report has a group of each service
+func svcSourceDir(name string) string {
+	return GenSvcClassDir(name) + "/sub/libjarsrc"
+}
+
+// parseReportFormats parses the comma separated formats of the plan, the unknown formats are skipped and returned in the error.
+// The default formats are taken when the plan picks no known format.
+func parseReportFormats(formats string) ([]string, error) {
+	var list []string
+	var unknown []string
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		if _, ok := reportFormats[format]; !ok {
+			unknown = append(unknown, format)
+			continue
+		}
+		list = append(list, format)
+	}
+	if len(list) <= 0 {
+		list = defaultReportFormats
+	}
+	if len(unknown) > 0 {
+		return list, fmt.Errorf("unknown report formats %v skipped", strings.Join(unknown, ","))
+	}
+	return list, nil
+}
+
+// writeReportFormats writes the project report in each format into dir, and returns the file of each format
+func writeReportFormats(formats []string, projectReport *coverage.Report, dir string) (map[string]string, error) {
+	var formatAddrs = map[string]string{}
+	for _, format := range formats {
+		fileName := fmt.Sprintf("%v/%v", dir, reportFormats[format].fileName)
+		err := writeReportFormat(reportFormats[format], projectReport, fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to report project %v cover, error %v", format, err)
+		}
+		formatAddrs[format] = fileName
+	}
+	return formatAddrs, nil
+}
+
+func writeReportFormat(format reportFormat, projectReport *coverage.Report, fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := format.write(f, projectReport); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
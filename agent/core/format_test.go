@@ -0,0 +1,51 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/coverage"
+)
+
+func TestWriteSonarArchive(t *testing.T) {
+	// the same class in two services
+	reportOf := func(covered int) *coverage.Report {
+		return &coverage.Report{Packages: []coverage.Package{{
+			Name:        "com/example",
+			SourceFiles: []coverage.SourceFile{{Name: "Order.java", Lines: []coverage.LineNode{{Nr: 3, CI: covered}}}},
+		}}}
+	}
+	project := coverage.GroupReports(projectReportName, map[string]*coverage.Report{"order": reportOf(1), "user": reportOf(0)})
+
+	var buf bytes.Buffer
+	if err := writeSonarArchive(&buf, project); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files = map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+
+	for name, data := range files {
+		if !strings.Contains(data, `<file path="com/example/Order.java">`) {
+			t.Errorf("%v = %v, want paths relative to the source root", name, data)
+		}
+	}
+	if len(files) != 2 || !strings.Contains(files["order.xml"], `covered="true"`) || !strings.Contains(files["user.xml"], `covered="false"`) {
+		t.Errorf("files = %v", files)
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/coverage"
+)
+
+// the name of the project report, it has a group of each service
+const projectReportName = "JaCoCo Coverage Report"
+
+var projectHtmlTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"counter": func(c coverage.Counter) string {
+		return fmt.Sprintf("%d/%d (%.1f%%)", c.Covered, c.Total(), c.Ratio()*100)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+<table border="1" cellspacing="0" cellpadding="4">
+<thead><tr><th>Service</th><th>Instructions</th><th>Branches</th><th>Lines</th><th>Methods</th><th>Classes</th></tr></thead>
+<tbody>
+{{- range .Summary.Services}}
+<tr><td><a href="{{.Name}}/index.html">{{.Name}}</a></td><td>{{counter .Counters.Instruction}}</td><td>{{counter .Counters.Branch}}</td><td>{{counter .Counters.Line}}</td><td>{{counter .Counters.Method}}</td><td>{{counter .Counters.Class}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot><tr><td>Total</td><td>{{counter .Summary.Counters.Instruction}}</td><td>{{counter .Summary.Counters.Branch}}</td><td>{{counter .Summary.Counters.Line}}</td><td>{{counter .Summary.Counters.Method}}</td><td>{{counter .Summary.Counters.Class}}</td></tr></tfoot>
+</table>
+</body>
+</html>
+`))
+
+// writeProjectHtml writes the html report of the project into dir, an index of the services
+// with the html report of each service under the dir of its name
+func writeProjectHtml(planID uint64, dir string, summary *coverage.Summary) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, svc := range summary.Services {
+		svcHtml := fmt.Sprintf("%v/%v_html", GenSvcReportDir(planID, svc.Name), svc.Name)
+		if err := simpleRun("", "cp", "-r", svcHtml, filepath.Join(dir, svc.Name)); err != nil {
+			return fmt.Errorf("copy svc %v html report error %v", svc.Name, err)
+		}
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	err = projectHtmlTemplate.Execute(f, struct {
+		Name    string
+		Summary *coverage.Summary
+	}{projectReportName, summary})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/martian/log"
+	corev1 "k8s.io/api/core/v1"
+	v1opt "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/erda-project/erda-sourcecov/agent/conf"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/registry"
+)
+
+const imagePullTimeout = 30 * time.Minute
+
+// pullFromImage extracts the dirs of the image of a pod container into their dest dirs,
+// pulling the exact image the container runs from its registry, and returns the labels of the image
+func pullFromImage(podName string, containerName string, dirs map[string]string, excludes []string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), imagePullTimeout)
+	defer cancel()
+
+	pod, err := clientSet.CoreV1().Pods(conf.Cfg.ProjectNs).Get(ctx, podName, v1opt.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get pod %v error %v", podName, err)
+	}
+	ref, err := containerImageOf(pod, containerName)
+	if err != nil {
+		return nil, err
+	}
+	keychain, err := pullSecretsOf(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	client := registry.NewClient(keychain)
+	client.Insecure = splitList(conf.Cfg.InsecureRegistries)
+	err = client.Extract(ctx, ref, dirs, func(name string) bool {
+		for _, part := range strings.Split(name, "/") {
+			if excluded(part, excludes) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := client.Config(ctx, ref)
+	if err != nil {
+		log.Errorf("get image %v config error %v", ref, err)
+		return nil, nil
+	}
+	return config.Config.Labels, nil
+}
+
+// containerImageOf returns the image of a pod container, by the digest of its status if it is running
+func containerImageOf(pod *corev1.Pod, containerName string) (*registry.Reference, error) {
+	var image string
+	for _, container := range pod.Spec.Containers {
+		if container.Name == containerName {
+			image = container.Image
+		}
+	}
+	if image == "" {
+		return nil, fmt.Errorf("container %v not found in pod %v", containerName, pod.Name)
+	}
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != containerName || !strings.Contains(status.ImageID, "@") {
+			continue
+		}
+		// the image id is the repo digest, which may be of another name of the image
+		digest := status.ImageID[strings.LastIndex(status.ImageID, "@")+1:]
+		if !strings.HasPrefix(digest, "sha256:") {
+			continue
+		}
+		ref.Digest = digest
+		ref.Tag = ""
+	}
+	return ref, nil
+}
+
+// pullSecretsOf returns the registry credentials of the image pull secrets of a pod and its service account
+func pullSecretsOf(ctx context.Context, pod *corev1.Pod) (registry.Keychain, error) {
+	var names []string
+	for _, secret := range pod.Spec.ImagePullSecrets {
+		names = append(names, secret.Name)
+	}
+	if pod.Spec.ServiceAccountName != "" {
+		account, err := clientSet.CoreV1().ServiceAccounts(pod.Namespace).Get(ctx, pod.Spec.ServiceAccountName, v1opt.GetOptions{})
+		if err == nil {
+			for _, secret := range account.ImagePullSecrets {
+				names = append(names, secret.Name)
+			}
+		}
+	}
+
+	var keychain = registry.Keychain{}
+	for _, name := range dedupe(names) {
+		secret, err := clientSet.CoreV1().Secrets(pod.Namespace).Get(ctx, name, v1opt.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get image pull secret %v error %v", name, err)
+		}
+		for _, key := range []string{corev1.DockerConfigJsonKey, corev1.DockerConfigKey} {
+			data, ok := secret.Data[key]
+			if !ok {
+				continue
+			}
+			if err := keychain.ParseDockerConfig(data); err != nil {
+				return nil, fmt.Errorf("parse image pull secret %v error %v", name, err)
+			}
+		}
+	}
+	return keychain, nil
+}
+
+// imageRootDirs returns the dest dir of each search root of the artifacts
+func imageRootDirs(destDir string, roots []string) map[string]string {
+	var dirs = map[string]string{}
+	for i, root := range roots {
+		dirs[root] = path.Join(destDir, strconv.Itoa(i))
+	}
+	return dirs
+}
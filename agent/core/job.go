@@ -1,11 +1,13 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +17,9 @@ import (
 	"k8s.io/client-go/util/retry"
 
 	"github.com/erda-project/erda-sourcecov/agent/conf"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/coverage"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/gitrepo"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/jacoco"
 	"github.com/erda-project/erda-sourcecov/agent/pkg/limit_wait_group"
 )
 
@@ -25,8 +30,15 @@ type DetectionJob struct {
 	MavenSettings string
 	Includes      string
 	Excludes      string
+	GitRepo       string
+	BaseRevision  string
+	HeadRevision  string
+	Diff          string
+	ReportFormats []string
 
-	ErrorMsg string
+	ErrorMsg     string
+	LatestExec   string
+	LatestReport *ProjectReport
 
 	ctx        context.Context
 	cancelFunc func()
@@ -37,6 +49,7 @@ var RunJobs = sync.Map{}
 
 func SetJob(planID uint64, job *DetectionJob) {
 	RunJobs.Store(planID, job)
+	saveState()
 }
 
 func GetJob(planID uint64) (*DetectionJob, bool) {
@@ -50,7 +63,19 @@ func GetJob(planID uint64) (*DetectionJob, bool) {
 
 func DeleteJob(planID uint64) {
 	RunJobs.Delete(planID)
+	reportLocks.Delete(planID)
+	saveState()
+}
+
+// the report locks by plan id, each report of a plan replaces the report dir of the plan,
+// so the reports of the plan and the api are generated and sent one at a time
+var reportLocks = sync.Map{}
 
+func lockReport(planID uint64) func() {
+	value, _ := reportLocks.LoadOrStore(planID, &sync.Mutex{})
+	lock := value.(*sync.Mutex)
+	lock.Lock()
+	return lock.Unlock
 }
 
 func WatchJob(ctx context.Context) {
@@ -62,6 +87,8 @@ func WatchJob(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
+			resendPendingCallbacks()
+
 			var detail *CodeCoverageExecRecordDetail
 			var err error
 			err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
@@ -104,6 +131,7 @@ func schedulingJob(planID uint64) {
 					log.Errorf("remove plan workdir error %v", err)
 				}
 				DeleteJob(planID)
+				deletePlanMetrics(planID)
 				return
 			default:
 				if job.Status == FailStatus || job.Status == SuccessStatus || job.Status == CancelStatus {
@@ -128,21 +156,53 @@ func schedulingJob(planID uint64) {
 		return
 	}
 
-	if job.JobStatus != Running {
+	switch job.JobStatus {
+	case Running:
+		if !prepareJob(planID) {
+			return
+		}
+	case Ready:
+		// resumed after a restart, the classes are extracted and the ready callback is sent
+		log.Infof("resume schedulingJob %v", planID)
+	default:
 		return
 	}
 
+	job, ok = GetJob(planID)
+	if !ok {
+		return
+	}
+
+	var loopTimes = 1
+	for {
+		select {
+		case <-job.ctx.Done():
+			return
+		case <-time.After(5 * time.Minute):
+			dumpExec(planID)
+			loopTimes++
+			if loopTimes > 10 {
+				mergeAllSvcExec(planID)
+				loopTimes = 1
+			}
+		}
+	}
+}
+
+// prepareJob extracts the classes of all services, dumps the pods to clear the coverage before the plan
+// and sends the ready callback
+func prepareJob(planID uint64) bool {
 	if !Exists(GenPlanDumpExecDir(planID)) {
 		err := os.Mkdir(GenPlanDumpExecDir(planID), 0777)
 		if err != nil {
-			return
+			return false
 		}
 	}
 
 	err := loadClassSources(planID)
 	if err != nil {
 		callbackEndWithMessage(planID, fmt.Sprintf("loadClassSources error %v", err))
-		return
+		return false
 	}
 
 	dumpExec(planID)
@@ -152,30 +212,18 @@ func schedulingJob(planID uint64) {
 	})
 	if err != nil {
 		callbackEndWithMessage(planID, fmt.Sprintf("callback ready error %v", err))
-		return
+		return false
 	}
 
-	job, ok = GetJob(planID)
+	job, ok := GetJob(planID)
 	if !ok {
-		return
+		return false
 	}
-	job.JobStatus = Ready
+	withStateLock(func() {
+		job.JobStatus = Ready
+	})
 	SetJob(planID, job)
-
-	var loopTimes = 1
-	for {
-		select {
-		case <-job.ctx.Done():
-			return
-		case <-time.After(5 * time.Minute):
-			dumpExec(planID)
-			loopTimes++
-			if loopTimes > 10 {
-				mergeAllSvcExec(planID)
-				loopTimes = 1
-			}
-		}
-	}
+	return true
 }
 
 func report(planID uint64) error {
@@ -186,17 +234,59 @@ func report(planID uint64) error {
 	if job.Status != EndingStatus {
 		return nil
 	}
+	return reportAndCallback(planID)
+}
+
+// reportAndCallback generates the project report of the plan and sends it to the center
+func reportAndCallback(planID uint64) error {
+	defer lockReport(planID)()
+
+	projectReport, err := generateReport(planID)
+	if err != nil {
+		return err
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return callbackEnd(planID, projectReport.ErrorMessage, SuccessStatus, projectReport.Result)
+	})
+	if err != nil {
+		return fmt.Errorf("report project cover xml error %v", err)
+	}
+
+	err = callbackReport(planID, SuccessStatus, projectReport.ErrorMessage, projectReport.HtmlTarAddr, projectReport.Result.Services)
+	if err != nil {
+		return fmt.Errorf("failed report project html tar.gz, error %v", err)
+	}
+
+	fmt.Println("end report all")
+	return nil
+}
+
+// ProjectReport is the report generated from the merged exec of all services of a plan
+type ProjectReport struct {
+	Dir          string        `json:"dir"`
+	ExecAddr     string        `json:"execAddr"`
+	HtmlTarAddr  string        `json:"htmlTarAddr"`
+	Result       *ReportResult `json:"result"`
+	ErrorMessage string        `json:"errorMessage,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt"`
+}
+
+// mergeProjectExec dumps and merges the exec of all services into the project exec of the plan,
+// and returns it with the merged exec of each service
+func mergeProjectExec(planID uint64) (string, map[string]string, error) {
+	job, ok := GetJob(planID)
+	if !ok {
+		return "", nil, fmt.Errorf("plan %v not found", planID)
+	}
 
 	dumpExec(planID)
 	svcExecMap := mergeAllSvcExec(planID)
 
 	job.DumpLock.Lock()
+	defer job.DumpLock.Unlock()
 	if len(svcExecMap) <= 0 {
-		return fmt.Errorf("not find svc exec dump file")
-	}
-	projectExec, err := os.CreateTemp("", "_project_.exec")
-	if err != nil {
-		return fmt.Errorf("create project exec dump file error %v", err)
+		return "", nil, fmt.Errorf("not find svc exec dump file")
 	}
 
 	var svcExecList []string
@@ -204,61 +294,218 @@ func report(planID uint64) error {
 		svcExecList = append(svcExecList, v)
 	}
 
-	err = mergeExec(projectExec.Name(), svcExecList)
+	projectExec := GenProjectExecAddr(planID)
+	start := time.Now()
+	err := mergeExec(projectExec, svcExecList)
+	mergeDuration.WithLabelValues("project").Observe(time.Since(start).Seconds())
 	if err != nil {
-		return fmt.Errorf("merge all svc exec dump error %v", err)
+		return "", nil, fmt.Errorf("merge all svc exec dump error %v", err)
 	}
-	job.DumpLock.Unlock()
+	withStateLock(func() {
+		job.LatestExec = projectExec
+	})
+	SetJob(planID, job)
+	return projectExec, svcExecMap, nil
+}
 
-	tempDir, err := os.MkdirTemp("", "")
-	if err != nil {
-		return fmt.Errorf("failed to create project xml temp file, error %v", err)
+// generateReport generates the project xml, html and the report formats of the plan into the plan report dir,
+// the caller holds the report lock of the plan
+func generateReport(planID uint64) (*ProjectReport, error) {
+	job, ok := GetJob(planID)
+	if !ok {
+		return nil, fmt.Errorf("plan %v not found", planID)
 	}
-	fileName := fmt.Sprintf("%v/%v", tempDir, "_project_xml")
-	_, err = os.Create(fmt.Sprintf("%v/%v", tempDir, "_project_xml"))
+
+	projectExec, svcExecMap, err := mergeProjectExec(planID)
 	if err != nil {
-		return fmt.Errorf("failed to create project xml temp file, error %v", err)
+		return nil, err
 	}
 
-	err = simpleRun("", "java", "-jar", conf.JacocoCliAddr, "report", projectExec.Name(), "--classfiles",
-		GenProjectClassDir()+"/sub/libjarcls", "--sourcefiles", GenProjectClassDir()+"/sub/libjarsrc", "--xml", fileName)
+	reportDir := GenPlanReportDir(planID)
+	err = os.RemoveAll(reportDir)
+	if err == nil {
+		err = os.MkdirAll(reportDir, 0755)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project report dir, error %v", err)
+	}
+	svcReports, svcXmlReports, err := reportServices(planID, svcExecMap)
 	if err != nil {
-		return fmt.Errorf("failed to report project xml cover, error %v", err)
+		return nil, fmt.Errorf("failed to report svc cover, error %v", err)
+	}
+	if len(svcReports) <= 0 {
+		return nil, fmt.Errorf("no svc has classes to report")
 	}
 
-	// 压缩
-	err = simpleRun("", "sh", "-c", fmt.Sprintf("cd %v && tar -czf %v %v", tempDir, "_project_xml.tar.gz", "_project_xml"))
+	// the project report has a group of each service, so classes of the same name in many services are not mixed
+	projectReport := coverage.GroupReports(projectReportName, svcXmlReports)
+	fileName := fmt.Sprintf("%v/%v", reportDir, "_project_xml")
+	err = projectReport.WriteFile(fileName)
 	if err != nil {
-		return fmt.Errorf("tar app report xml error %v", err)
+		return nil, fmt.Errorf("failed to report project xml cover, error %v", err)
+	}
+	summary := coverage.Summarize("", projectReport, nil)
+	for _, svcReport := range svcReports {
+		summary.Services = append(summary.Services, coverage.Summarize(svcReport.Name, svcXmlReports[svcReport.Name], nil))
 	}
+	setProjectCoverage(planID, summary)
 
 	var errorMessage = buildCallbackErrorMessage(planID)
-	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		return callbackEnd(planID, errorMessage, SuccessStatus, fmt.Sprintf("%v/%v", tempDir, "_project_xml.tar.gz"))
-	})
+	mismatches, err := checkClassVersions(svcExecMap)
 	if err != nil {
-		return fmt.Errorf("report project cover xml error %v", err)
+		log.Errorf("failed to check class versions, error %v", err)
+	}
+	for _, m := range mismatches {
+		if m.Total > 0 {
+			errorMessage += fmt.Sprintf("svc %v has %v classes whose exec data is of another build, their coverage is dropped\n", m.Service, m.Total)
+		}
+		if len(m.UncheckedArtifacts) > 0 {
+			errorMessage += fmt.Sprintf("svc %v class versions of %v not checked, their extraction is not found\n", m.Service, strings.Join(m.UncheckedArtifacts, ","))
+		}
+	}
+	diffSummary, err := diffCoverage(job, projectReport)
+	if err != nil {
+		log.Errorf("failed to compute diff cover, error %v", err)
+		errorMessage += fmt.Sprintf("diff cover error %v\n", err)
 	}
 
-	err = simpleRun("", "java", "-jar", conf.JacocoCliAddr, "report", projectExec.Name(), "--classfiles",
-		GenProjectClassDir()+"/sub/libjarcls", "--sourcefiles", GenProjectClassDir()+"/sub/libjarsrc", "--html", fmt.Sprintf("%v/%v", tempDir, "_project_html"))
+	formatAddrs, err := writeReportFormats(job.ReportFormats, projectReport, reportDir)
 	if err != nil {
-		return fmt.Errorf("faild report porject html, error %v", err)
+		return nil, err
 	}
 
-	var times = time.Now().Format("20060102150405")
-	err = simpleRun("", "sh", "-c", fmt.Sprintf("cd %v && tar -czf %v %v", tempDir, times+".tar.gz", "_project_html"))
+	// 压缩
+	err = simpleRun("", "sh", "-c", fmt.Sprintf("cd %v && tar -czf %v %v", reportDir, "_project_xml.tar.gz", "_project_xml"))
 	if err != nil {
-		return fmt.Errorf("failed tar project html dir, error %v", err)
+		return nil, fmt.Errorf("tar app report xml error %v", err)
 	}
 
-	err = callbackReport(planID, SuccessStatus, errorMessage, fmt.Sprintf("%v/%v", tempDir, times+".tar.gz"))
+	err = writeProjectHtml(planID, fmt.Sprintf("%v/%v", reportDir, "_project_html"), summary)
 	if err != nil {
-		return fmt.Errorf("failed report project html tar.gz, error %v", err)
+		return nil, fmt.Errorf("faild report porject html, error %v", err)
 	}
 
-	fmt.Println("end report all")
-	return nil
+	var times = time.Now().Format("20060102150405")
+	err = simpleRun("", "sh", "-c", fmt.Sprintf("cd %v && tar -czf %v %v", reportDir, times+".tar.gz", "_project_html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed tar project html dir, error %v", err)
+	}
+
+	var result = ProjectReport{
+		Dir:         reportDir,
+		ExecAddr:    projectExec,
+		HtmlTarAddr: fmt.Sprintf("%v/%v", reportDir, times+".tar.gz"),
+		Result: &ReportResult{
+			XmlTarAddr:      fmt.Sprintf("%v/%v", reportDir, "_project_xml.tar.gz"),
+			FormatAddrs:     formatAddrs,
+			Summary:         summary,
+			DiffCoverage:    diffSummary,
+			ClassMismatches: mismatches,
+			Services:        svcReports,
+		},
+		ErrorMessage: errorMessage,
+		CreatedAt:    time.Now(),
+	}
+
+	job, ok = GetJob(planID)
+	if ok {
+		withStateLock(func() {
+			job.LatestReport = &result
+		})
+		SetJob(planID, job)
+	}
+	return &result, nil
+}
+
+// ServiceReport is the report of the merged exec of a service against the classes of its own artifacts
+type ServiceReport struct {
+	Name        string `json:"name"`
+	ExecAddr    string `json:"execAddr"`
+	XmlTarAddr  string `json:"xmlTarAddr"`
+	HtmlTarAddr string `json:"htmlTarAddr"`
+}
+
+// reportServices generates the xml and html report of each service exec into the service report dirs,
+// and returns them with the parsed xml report of each service
+func reportServices(planID uint64, svcExecMap map[string]string) ([]*ServiceReport, map[string]*coverage.Report, error) {
+	var svcNames []string
+	for name := range svcExecMap {
+		svcNames = append(svcNames, name)
+	}
+	sort.Strings(svcNames)
+
+	var reports []*ServiceReport
+	var xmlReports = map[string]*coverage.Report{}
+	for _, name := range svcNames {
+		classDir := GenSvcClassDir(name)
+		if !Exists(classDir + "/sub/libjarcls") {
+			log.Errorf("svc %v has no classes extracted, skip its report", name)
+			continue
+		}
+		reportDir := GenSvcReportDir(planID, name)
+		if err := os.MkdirAll(reportDir, 0755); err != nil {
+			return nil, nil, err
+		}
+
+		xmlFile := fmt.Sprintf("%v/%v_xml", reportDir, name)
+		err := simpleRun("", "java", "-jar", conf.JacocoCliAddr, "report", svcExecMap[name], "--name", name,
+			"--classfiles", classDir+"/sub/libjarcls", "--sourcefiles", classDir+"/sub/libjarsrc",
+			"--xml", xmlFile, "--html", fmt.Sprintf("%v/%v_html", reportDir, name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("report svc %v error %v", name, err)
+		}
+		xmlReports[name], err = coverage.ParseFile(xmlFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, kind := range []string{"xml", "html"} {
+			// the service name is passed as an argument, never through a shell
+			dir := fmt.Sprintf("%v_%v", name, kind)
+			err = simpleRun("", "tar", "-C", reportDir, "-czf", fmt.Sprintf("%v/%v.tar.gz", reportDir, dir), "--", dir)
+			if err != nil {
+				return nil, nil, fmt.Errorf("tar svc %v report %v error %v", name, kind, err)
+			}
+		}
+		reports = append(reports, &ServiceReport{
+			Name:        name,
+			ExecAddr:    svcExecMap[name],
+			XmlTarAddr:  fmt.Sprintf("%v/%v_xml.tar.gz", reportDir, name),
+			HtmlTarAddr: fmt.Sprintf("%v/%v_html.tar.gz", reportDir, name),
+		})
+	}
+	return reports, xmlReports, nil
+}
+
+// diffCoverage computes the coverage of the lines changed in the plan, using the diff given by the plan
+// or the diff between the base and head revision of the plan repository
+func diffCoverage(job *DetectionJob, projectReport *coverage.Report) (*coverage.DiffSummary, error) {
+	var diff []byte
+	switch {
+	case job.Diff != "":
+		diff = []byte(job.Diff)
+	case job.GitRepo != "" && job.BaseRevision != "":
+		repo, err := gitrepo.Sync(job.GitRepo, GenGitRepoDir(job.GitRepo))
+		if err != nil {
+			return nil, err
+		}
+		var headRevision = job.HeadRevision
+		if headRevision == "" {
+			headRevision = "HEAD"
+		}
+		diff, err = repo.Diff(job.BaseRevision, headRevision)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	changes, err := coverage.ParseUnifiedDiff(bytes.NewReader(diff))
+	if err != nil {
+		return nil, err
+	}
+	return coverage.DiffCoverage(projectReport, changes), nil
 }
 
 func callbackEndWithMessage(planID uint64, message string) {
@@ -266,12 +513,14 @@ func callbackEndWithMessage(planID uint64, message string) {
 	if !ok {
 		return
 	}
-	job.Status = FailStatus
-	job.JobStatus = Fail
+	withStateLock(func() {
+		job.Status = FailStatus
+		job.JobStatus = Fail
+	})
 	job.cancelFunc()
 	SetJob(planID, job)
 	log.Errorf(message)
-	err := callbackEnd(planID, message, FailStatus, "")
+	err := callbackEnd(planID, message, FailStatus, nil)
 	if err != nil {
 		log.Errorf("callback end error %v", err)
 		return
@@ -286,7 +535,7 @@ func buildCallbackErrorMessage(planID uint64) string {
 
 	var msg = ""
 	if job.ErrorMsg != "" {
-		msg += job.ErrorMsg + "job error message: \n"
+		msg += "job error message: \n"
 		msg += job.ErrorMsg + "\n"
 	}
 
@@ -332,7 +581,9 @@ func buildCallbackErrorMessage(planID uint64) string {
 }
 
 func loadClassSources(planID uint64) error {
-	var jarAddrList []string
+	job, _ := GetJob(planID)
+	var svcNames []string
+	var svcSources = map[string]SourceRepo{}
 	Services.Range(func(key, value interface{}) bool {
 		svc, ok := GetService(key.(string))
 		if !ok {
@@ -343,22 +594,26 @@ func loadClassSources(planID uint64) error {
 			return true
 		}
 
-		for _, jarAddr := range svc.JarAddrList {
-			jarAddrList = append(jarAddrList, jarAddr)
+		if len(svc.JarAddrList) > 0 {
+			svcNames = append(svcNames, svc.Name)
+		}
+		if repo, ok := sourceRepoOf(svc, job); ok {
+			svcSources[svc.Name] = repo
 		}
 		return true
 	})
 
-	if len(jarAddrList) <= 0 {
+	if len(svcNames) <= 0 {
 		return fmt.Errorf("all service not find jar path")
 	}
 
-	jarAddrStr := strings.Join(jarAddrList, ",")
-	err := simpleRun("", "/bin/bash", "-lc", fmt.Sprintf("bash %v %v %v", conf.ExtractCliAddr, jarAddrStr, GenProjectClassDir()))
+	start := time.Now()
+	err := buildClassTrees(svcNames, svcSources, job)
+	classExtractionDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		log.Errorf("failed to get all svc jar classes and sources, error %v", err)
 		callbackError := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-			return callbackEnd(planID, fmt.Sprintf("failed to get all svc jar classes and sources, error %v", err), FailStatus, "")
+			return callbackEnd(planID, fmt.Sprintf("failed to get all svc jar classes and sources, error %v", err), FailStatus, nil)
 		})
 		if callbackError != nil {
 			log.Errorf("error to callback %v, error %v", FailStatus, err)
@@ -417,37 +672,29 @@ func dumpExec(planID uint64) {
 
 				var podExecList []string
 				var podErrorMap = map[string]string{}
-				for podIndex, pod := range svc.Pods {
+				var podDumpedMap = map[string]time.Time{}
+				for _, pod := range svc.Pods {
 
 					if pod.HasError {
 						continue
 					}
 
-					conn, err := net.DialTimeout("tcp", pod.Addr+":6300", 5*time.Second)
+					podExec, err := dumpPod(svc, pod)
 					if err != nil {
-						podErrorMap[pod.Addr] = fmt.Sprintf("svc %v container %v fail to dial error %v", svc.Name, pod.Addr, err)
+						podErrorMap[pod.Addr] = fmt.Sprintf("svc %v container %v %v", svc.Name, pod.Addr, err)
 						continue
 					}
-					conn.Close()
-
-					f, err := os.CreateTemp("", "svc_pod_"+strconv.FormatInt(int64(podIndex), 10))
-					if err != nil {
-						podErrorMap[pod.Addr] = fmt.Sprintf("svc %v container %v fail to create temp file error %v", svc.Name, pod.Addr, err)
-						return
-					}
-
-					err = simpleRun("", "java", "-jar", conf.JacocoCliAddr, "dump", "--address", pod.Addr, "--destfile", f.Name(), "--port", "6300", "--reset")
-					if err != nil {
-						podErrorMap[pod.Addr] = fmt.Sprintf("svc %v container %v dump exec error %v", svc.Name, pod.Addr, err)
-						return
-					}
-					podExecList = append(podExecList, f.Name())
+					podExecList = append(podExecList, podExec)
+					podDumpedMap[pod.Addr] = time.Now()
 				}
 
 				err = mergeExec(svcExec.Name(), podExecList)
 				if err != nil {
 					svcErrorMessage += fmt.Sprintf("merge pod exec error %v", err)
 				}
+				for _, podExec := range podExecList {
+					os.Remove(podExec)
+				}
 
 				nowSvc, ok := GetService(key)
 				if !ok {
@@ -456,13 +703,19 @@ func dumpExec(planID uint64) {
 				if nowSvc.IsDelete {
 					return
 				}
-				nowSvc.ErrorMessage += svcErrorMessage
-				for podIndex := range nowSvc.Pods {
-					if podErrorMap[nowSvc.Pods[podIndex].Addr] != "" {
-						nowSvc.Pods[podIndex].ErrorMsg = podErrorMap[nowSvc.Pods[podIndex].Addr]
-						nowSvc.Pods[podIndex].HasError = true
+				withStateLock(func() {
+					nowSvc.ErrorMessage += svcErrorMessage
+					for podIndex := range nowSvc.Pods {
+						if podErrorMap[nowSvc.Pods[podIndex].Addr] != "" {
+							nowSvc.Pods[podIndex].ErrorMsg = podErrorMap[nowSvc.Pods[podIndex].Addr]
+							nowSvc.Pods[podIndex].HasError = true
+						}
+						if dumpedAt, ok := podDumpedMap[nowSvc.Pods[podIndex].Addr]; ok {
+							nowSvc.Pods[podIndex].Dumps++
+							nowSvc.Pods[podIndex].LastDumpAt = dumpedAt
+						}
 					}
-				}
+				})
 				SetService(svc.Name, nowSvc)
 			}(key.(string))
 		}
@@ -472,6 +725,140 @@ func dumpExec(planID uint64) {
 	return
 }
 
+// dumpPod dumps and resets the exec of every jvm of the pod, merged into a temp file.
+// It only fails when no jvm is dumped, the jvms failing besides are logged.
+func dumpPod(svc *Service, pod Pod) (string, error) {
+	start := time.Now()
+	var endpointExecList []string
+	var errorMessages []string
+	for _, endpoint := range pod.endpoints() {
+		f, err := os.CreateTemp("", "svc_pod_"+pod.PodName+"_*.exec")
+		if err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("fail to create temp file error %v", err))
+			continue
+		}
+		f.Close()
+
+		err = jacoco.DefaultClient.DumpToFile(net.JoinHostPort(pod.Addr, strconv.Itoa(endpoint.Port)), f.Name(), true)
+		if err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("container %v %v", endpoint.Container, err))
+			os.Remove(f.Name())
+			continue
+		}
+		endpointExecList = append(endpointExecList, f.Name())
+	}
+	defer func() {
+		for _, endpointExec := range endpointExecList {
+			os.Remove(endpointExec)
+		}
+	}()
+
+	var err error
+	if len(endpointExecList) <= 0 {
+		err = fmt.Errorf("%v", strings.Join(errorMessages, ", "))
+		observeDump(svc.Name, pod.PodName, start, "", err)
+		return "", err
+	}
+	if len(errorMessages) > 0 {
+		log.Errorf("svc %v pod %v dump error %v", svc.Name, pod.PodName, strings.Join(errorMessages, ", "))
+	}
+
+	f, err := os.CreateTemp("", "svc_pod_"+pod.PodName+"_*.exec")
+	if err == nil {
+		f.Close()
+		err = mergeExec(f.Name(), endpointExecList)
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("merge pod exec error %v", err)
+		observeDump(svc.Name, pod.PodName, start, "", err)
+		return "", err
+	}
+	observeDump(svc.Name, pod.PodName, start, f.Name(), nil)
+	return f.Name(), nil
+}
+
+// dumpTerminatingPod dumps a pod being deleted into the exec dir of its service for each running plan,
+// so the coverage collected since the last dump is not lost in rollouts and scale-downs
+func dumpTerminatingPod(podName string) {
+	svc, pod, ok := findServicePod(podName)
+	if !ok || pod.HasError {
+		return
+	}
+
+	var jobs []*DetectionJob
+	RunJobs.Range(func(key, value interface{}) bool {
+		job := value.(*DetectionJob)
+		if job.ctx == nil || job.ctx.Err() != nil {
+			return true
+		}
+		switch job.Status {
+		case SuccessStatus, FailStatus, CancelStatus:
+			return true
+		}
+		jobs = append(jobs, job)
+		return true
+	})
+	if len(jobs) <= 0 {
+		return
+	}
+
+	log.Infof("dump terminating pod %v of svc %v", pod.PodName, svc.Name)
+	podExec, err := dumpPod(svc, pod)
+	if err != nil {
+		log.Errorf("dump terminating pod %v of svc %v error %v", pod.PodName, svc.Name, err)
+		return
+	}
+	defer os.Remove(podExec)
+
+	for _, job := range jobs {
+		err := saveTerminatingPodExec(job, svc.Name, pod.PodName, podExec)
+		if err != nil {
+			log.Errorf("save terminating pod %v exec of plan %v error %v", pod.PodName, job.PlanID, err)
+		}
+	}
+
+	nowSvc, ok := GetService(svc.Name)
+	if !ok {
+		return
+	}
+	var running bool
+	withStateLock(func() {
+		for podIndex := range nowSvc.Pods {
+			if nowSvc.Pods[podIndex].PodName == pod.PodName {
+				nowSvc.Pods[podIndex].Dumps++
+				nowSvc.Pods[podIndex].LastDumpAt = time.Now()
+				running = true
+			}
+		}
+	})
+	SetService(nowSvc.Name, nowSvc)
+	// the pod left its service while it was dumped, the series of the dump are not kept
+	if !running {
+		deletePodMetrics(svc.Name, pod.PodName)
+	}
+}
+
+// saveTerminatingPodExec copies the pod exec into the service exec dir, the dump lock keeps
+// mergeAllSvcExec from removing it half written
+func saveTerminatingPodExec(job *DetectionJob, svcName string, podName string, podExec string) error {
+	job.DumpLock.Lock()
+	defer job.DumpLock.Unlock()
+
+	err := os.MkdirAll(GenSvcDumpExecDir(job.PlanID, svcName), 0755)
+	if err != nil {
+		return err
+	}
+	svcExec, err := os.CreateTemp(GenSvcDumpExecDir(job.PlanID, svcName), "pod_"+podName+"_*.exec")
+	if err != nil {
+		return err
+	}
+	svcExec.Close()
+	return mergeExec(svcExec.Name(), []string{podExec})
+}
+
 func mergeAllSvcExec(planID uint64) map[string]string {
 	job, ok := GetJob(planID)
 
@@ -516,7 +903,9 @@ func mergeAllSvcExec(planID uint64) map[string]string {
 				return true
 			}
 
+			start := time.Now()
 			err = mergeExec(svcExec.Name(), svcExecList)
+			mergeDuration.WithLabelValues("service").Observe(time.Since(start).Seconds())
 			if err != nil {
 				nowSvc, ok := GetService(svc.Name)
 				if !ok {
@@ -525,7 +914,9 @@ func mergeAllSvcExec(planID uint64) map[string]string {
 				if nowSvc.IsDelete {
 					return true
 				}
-				nowSvc.ErrorMessage += fmt.Sprintf("merge pod exec error %v", err)
+				withStateLock(func() {
+					nowSvc.ErrorMessage += fmt.Sprintf("merge pod exec error %v", err)
+				})
 				SetService(svc.Name, nowSvc)
 			}
 
@@ -544,20 +935,7 @@ func mergeAllSvcExec(planID uint64) map[string]string {
 }
 
 func mergeExec(destFile string, files []string) error {
-	var args []string
-	args = append(args, "-jar")
-	args = append(args, conf.JacocoCliAddr)
-	args = append(args, "merge")
-	for _, file := range files {
-		args = append(args, file)
-	}
-	args = append(args, "--destfile")
-	args = append(args, destFile)
-	err := simpleRun("", "java", args...)
-	if err != nil {
-		return err
-	}
-	return nil
+	return jacoco.MergeFiles(destFile, files)
 }
 
 func saveJob(detail *CodeCoverageExecRecordDetail) {
@@ -568,12 +946,26 @@ func saveJob(detail *CodeCoverageExecRecordDetail) {
 	job, ok := GetJob(detail.PlanID)
 
 	if !ok || job.PlanID != detail.PlanID {
+		// the plan is still reported in the known formats, the unknown ones are noted in the end callback
+		var errorMsg string
+		reportFormats, err := parseReportFormats(detail.ReportFormats)
+		if err != nil {
+			log.Errorf("plan %v report formats error %v", detail.PlanID, err)
+			errorMsg = err.Error()
+		}
+
 		var newJob = DetectionJob{
 			PlanID:        detail.PlanID,
 			Status:        detail.Status,
 			MavenSettings: detail.MavenSetting,
 			Includes:      detail.Includes,
 			Excludes:      detail.Excludes,
+			GitRepo:       detail.GitRepo,
+			BaseRevision:  detail.BaseRevision,
+			HeadRevision:  detail.HeadRevision,
+			Diff:          detail.Diff,
+			ReportFormats: reportFormats,
+			ErrorMsg:      errorMsg,
 		}
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -589,31 +981,14 @@ func saveJob(detail *CodeCoverageExecRecordDetail) {
 			return true
 		})
 
-		if !Exists("/usr/share/maven/conf/settings.xml") {
-			err := simpleRun("", "bash", "-c", "cd /usr/share/maven/conf && touch settings.xml")
-			if err != nil {
-				log.Errorf("error to create setting file %v", err)
-				return
-			}
-		}
-		f, err := os.OpenFile("/usr/share/maven/conf/settings.xml", os.O_WRONLY|os.O_TRUNC, 0600)
-		if err != nil {
-			log.Errorf("error to write to setting file %v", err)
-			return
-		}
-		defer f.Close()
-		_, err = f.WriteString(detail.MavenSetting)
-		if err != nil {
-			return
-		}
-
-		os.Setenv("INCLUDES", detail.Includes)
-		os.Setenv("EXCLUDES", detail.Excludes)
-
 		SetJob(detail.PlanID, &newJob)
+		setPlanStatus(detail.PlanID, newJob.Status)
 		go schedulingJob(detail.PlanID)
 	} else {
-		job.Status = detail.Status
+		withStateLock(func() {
+			job.Status = detail.Status
+		})
 		SetJob(detail.PlanID, job)
+		setPlanStatus(detail.PlanID, job.Status)
 	}
 }
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/coverage"
+)
+
+const metricsNamespace = "sourcecov_agent"
+
+var (
+	dumpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dump_duration_seconds",
+		Help:      "Duration of dumping the exec of a pod.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"service", "pod"})
+	dumpFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dump_failures_total",
+		Help:      "Number of failed dumps of a pod.",
+	}, []string{"service", "pod"})
+	dumpExecBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dump_exec_bytes",
+		Help:      "Size of the exec of the last successful dump of a pod.",
+	}, []string{"service", "pod"})
+	dumpLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dump_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful dump of a pod.",
+	}, []string{"service", "pod"})
+	mergeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "merge_duration_seconds",
+		Help:      "Duration of merging the exec of a service or of the project.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"scope"})
+	classExtractionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "class_extraction_duration_seconds",
+		Help:      "Duration of extracting the classes and sources of all services.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	extractCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "extract_cache_lookups_total",
+		Help:      "Number of lookups of the extracted classes of an artifact by result.",
+	}, []string{"result"})
+	extractCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "extract_cache_bytes",
+		Help:      "Size of the cache of extracted classes and sources.",
+	})
+	classMismatchCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "class_mismatches",
+		Help:      "Number of classes of a service whose exec data mismatches the extracted classes in the last report.",
+	}, []string{"service"})
+	callbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "callbacks_total",
+		Help:      "Number of callbacks to the center by endpoint and result.",
+	}, []string{"endpoint", "result"})
+	planStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plan_status",
+		Help:      "Current status of a plan, 1 for the current status.",
+	}, []string{"plan_id", "status"})
+	projectCoverageRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "project_coverage_ratio",
+		Help:      "Coverage ratio of the last project report by counter.",
+	}, []string{"plan_id", "counter"})
+)
+
+var planStatuses = []CodeCoverageExecStatus{ReadyStatus, RunningStatus, EndingStatus, SuccessStatus, FailStatus, CancelStatus}
+
+func init() {
+	prometheus.MustRegister(dumpDuration, dumpFailures, dumpExecBytes, dumpLastSuccess, mergeDuration,
+		classExtractionDuration, extractCacheLookups, extractCacheBytes, classMismatchCount, callbacks, planStatus, projectCoverageRatio)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+func observeDump(svcName string, podName string, start time.Time, execFile string, err error) {
+	dumpDuration.WithLabelValues(svcName, podName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dumpFailures.WithLabelValues(svcName, podName).Inc()
+		return
+	}
+	if info, err := os.Stat(execFile); err == nil {
+		dumpExecBytes.WithLabelValues(svcName, podName).Set(float64(info.Size()))
+	}
+	dumpLastSuccess.WithLabelValues(svcName, podName).SetToCurrentTime()
+}
+
+// deletePodMetrics removes the series of a pod no longer in its service, as when it is replaced in a rollout
+func deletePodMetrics(svcName string, podName string) {
+	dumpDuration.DeleteLabelValues(svcName, podName)
+	dumpFailures.DeleteLabelValues(svcName, podName)
+	dumpExecBytes.DeleteLabelValues(svcName, podName)
+	dumpLastSuccess.DeleteLabelValues(svcName, podName)
+}
+
+// deleteServiceMetrics removes the series of a deleted service and of its pods
+func deleteServiceMetrics(svc *Service) {
+	for _, pod := range svc.Pods {
+		deletePodMetrics(svc.Name, pod.PodName)
+	}
+	classMismatchCount.DeleteLabelValues(svc.Name)
+}
+
+func observeCallback(endpoint string, err error) {
+	var result = "success"
+	if err != nil {
+		result = "failure"
+	}
+	callbacks.WithLabelValues(endpoint, result).Inc()
+}
+
+func observeExtractCache(hit bool, err error) {
+	var result = "miss"
+	switch {
+	case err != nil:
+		result = "failure"
+	case hit:
+		result = "hit"
+	}
+	extractCacheLookups.WithLabelValues(result).Inc()
+}
+
+func setPlanStatus(planID uint64, status CodeCoverageExecStatus) {
+	for _, s := range planStatuses {
+		var value float64
+		if s == status {
+			value = 1
+		}
+		planStatus.WithLabelValues(fmt.Sprint(planID), string(s)).Set(value)
+	}
+}
+
+func setProjectCoverage(planID uint64, summary *coverage.Summary) {
+	if summary == nil {
+		return
+	}
+	for name, counter := range map[string]coverage.Counter{
+		coverage.CounterInstruction: summary.Counters.Instruction,
+		coverage.CounterBranch:      summary.Counters.Branch,
+		coverage.CounterLine:        summary.Counters.Line,
+		coverage.CounterMethod:      summary.Counters.Method,
+		coverage.CounterClass:       summary.Counters.Class,
+	} {
+		projectCoverageRatio.WithLabelValues(fmt.Sprint(planID), name).Set(counter.Ratio())
+	}
+}
+
+// deletePlanMetrics removes the series of a plan removed from the agent
+func deletePlanMetrics(planID uint64) {
+	for _, s := range planStatuses {
+		planStatus.DeleteLabelValues(fmt.Sprint(planID), string(s))
+	}
+	for _, name := range []string{coverage.CounterInstruction, coverage.CounterBranch, coverage.CounterLine,
+		coverage.CounterMethod, coverage.CounterClass} {
+		projectCoverageRatio.DeleteLabelValues(fmt.Sprint(planID), name)
+	}
+}
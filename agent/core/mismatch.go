@@ -0,0 +1,114 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/jacoco"
+)
+
+// how many mismatched classes of a service are listed, all of them are counted
+const maxClassMismatches = 100
+
+// ClassMismatch is a class whose execution data was recorded for another build than the class extracted from the artifacts,
+// jacoco drops such data from the report
+type ClassMismatch struct {
+	Name     string   `json:"name"`
+	ExecID   string   `json:"execId"`
+	ClassIDs []string `json:"classIds"`
+}
+
+// ServiceClassMismatches is the mismatched classes of a service, as when it is redeployed during a plan.
+// The classes of the artifacts whose extraction is not found, as evicted from the extract cache, are not checked.
+type ServiceClassMismatches struct {
+	Service            string          `json:"service"`
+	Total              int             `json:"total"`
+	Classes            []ClassMismatch `json:"classes"`
+	UncheckedArtifacts []string        `json:"uncheckedArtifacts,omitempty"`
+}
+
+// the mismatches of the last report by service name
+var classMismatches = sync.Map{}
+
+// checkClassVersions compares the class ids of the merged exec of each service with the ids of the classes
+// extracted from its artifacts, the classes not extracted, as the third party libraries, are not checked
+func checkClassVersions(svcExecMap map[string]string) ([]*ServiceClassMismatches, error) {
+	var names []string
+	for name := range svcExecMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []*ServiceClassMismatches
+	for _, name := range names {
+		svc, ok := GetService(name)
+		if !ok {
+			continue
+		}
+		execData, err := jacoco.ReadFile(svcExecMap[name])
+		if err != nil {
+			return nil, err
+		}
+
+		var artifacts []string
+		var keys map[string]string
+		withStateLock(func() {
+			artifacts, keys = svc.JarAddrList, svc.ExtractKeys
+		})
+		unchecked := loadExtractions(artifacts, keys)
+
+		mismatches := compareClassIDs(name, execData, getClassIDs(artifacts))
+		mismatches.UncheckedArtifacts = unchecked
+		classMismatchCount.WithLabelValues(name).Set(float64(mismatches.Total))
+		if mismatches.Total <= 0 && len(unchecked) <= 0 {
+			classMismatches.Delete(name)
+			continue
+		}
+		classMismatches.Store(name, mismatches)
+		result = append(result, mismatches)
+	}
+	return result, nil
+}
+
+func compareClassIDs(service string, execData *jacoco.ExecData, classIDs map[string][]uint64) *ServiceClassMismatches {
+	var mismatches = ServiceClassMismatches{Service: service}
+	for _, data := range execData.Classes() {
+		ids, ok := classIDs[data.Name]
+		if !ok || containsClassID(ids, data.ID) {
+			continue
+		}
+		mismatches.Total++
+		if len(mismatches.Classes) >= maxClassMismatches {
+			continue
+		}
+		var mismatch = ClassMismatch{Name: data.Name, ExecID: formatClassID(data.ID)}
+		for _, id := range ids {
+			mismatch.ClassIDs = append(mismatch.ClassIDs, formatClassID(id))
+		}
+		mismatches.Classes = append(mismatches.Classes, mismatch)
+	}
+	return &mismatches
+}
+
+func containsClassID(ids []uint64, id uint64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func formatClassID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+// getClassMismatches returns the mismatches of a service found by the last report
+func getClassMismatches(service string) *ServiceClassMismatches {
+	value, ok := classMismatches.Load(service)
+	if !ok {
+		return nil
+	}
+	return value.(*ServiceClassMismatches)
+}
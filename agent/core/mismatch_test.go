@@ -0,0 +1,78 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractcache"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/jacoco"
+)
+
+func TestCheckClassVersionsAfterRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mismatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	classCacheOnce.Do(func() {})
+	classCache, classCacheErr = extractcache.New(filepath.Join(dir, "cache"), 1<<20)
+	if classCacheErr != nil {
+		t.Fatal(classCacheErr)
+	}
+	class := []byte("order class of the extracted build")
+	_, release, _, err := classCache.Get("order-key", func(entry string) error {
+		classDir := filepath.Join(entry, "libjarcls")
+		os.MkdirAll(filepath.Join(classDir, "com/example"), 0755)
+		ioutil.WriteFile(filepath.Join(classDir, "com/example/Order.class"), class, 0644)
+		ioutil.WriteFile(filepath.Join(entry, extractResultFile), []byte("{}"), 0644)
+		return writeClassIDs(classDir, filepath.Join(entry, extractClassIDsFile))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// the state saved before the restart, the extraction is only known by its key
+	jar := filepath.Join(dir, "order.jar")
+	ioutil.WriteFile(jar, []byte("jar"), 0644)
+	data, _ := json.Marshal(serviceState{Name: "order-restored", JarAddrList: []string{jar}, ExtractKeys: map[string]string{jar: "order-key"}})
+	var state serviceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	restoreService(&state)
+	defer DeleteService("order-restored")
+	defer extractions.Delete(jar)
+	defer extractionClassIDs.Delete(jar)
+
+	execData := jacoco.NewExecData()
+	execData.AddClass(&jacoco.ExecutionData{ID: jacoco.ClassID(class) + 1, Name: "com/example/Order", Probes: []bool{true}})
+	exec := filepath.Join(dir, "order.exec")
+	if err := execData.WriteFile(exec); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := checkClassVersions(map[string]string{"order-restored": exec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Total != 1 || mismatches[0].Classes[0].Name != "com/example/Order" {
+		t.Fatalf("mismatches = %+v", mismatches)
+	}
+
+	// an extraction evicted from the cache is not checked, and said so
+	extractionClassIDs.Delete(jar)
+	svc, _ := GetService("order-restored")
+	svc.ExtractKeys = map[string]string{jar: "evicted-key"}
+	mismatches, err = checkClassVersions(map[string]string{"order-restored": exec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Total != 0 || len(mismatches[0].UncheckedArtifacts) != 1 {
+		t.Errorf("mismatches = %+v", mismatches)
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/martian/log"
+
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractcache"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/extractor"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/gitrepo"
+)
+
+// the sources of the application of a service are the java and kotlin source roots of all modules of its repository by default
+var defaultSourceRoots = []string{"**/src/main/java", "**/src/main/kotlin"}
+
+// labels of the image naming the repository and revision it is built from
+const (
+	LabelImageSource   = "org.opencontainers.image.source"
+	LabelImageRevision = "org.opencontainers.image.revision"
+)
+
+// SourceRepo is where the sources of the application of a service are in a git repository
+type SourceRepo struct {
+	URL      string `json:"url,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// globs of the source roots relative to the repository, ** matches any dirs
+	Roots []string `json:"roots,omitempty"`
+}
+
+func (s SourceRepo) roots() []string {
+	if len(s.Roots) > 0 {
+		return s.Roots
+	}
+	return defaultSourceRoots
+}
+
+func (s SourceRepo) key() string {
+	return s.URL + " " + s.Revision + " " + strings.Join(s.roots(), ",")
+}
+
+// sourceRepoOfLabels returns the repository and revision of the labels of an image
+func sourceRepoOfLabels(labels map[string]string) SourceRepo {
+	return SourceRepo{URL: labels[LabelImageSource], Revision: labels[LabelImageRevision]}
+}
+
+// sourceRepoOf returns the sources of a service, named by its annotations, then the labels of its image, then the plan.
+// The head revision of the plan is only taken for the repository of the plan, the default branch is taken without revision.
+func sourceRepoOf(svc *Service, job *DetectionJob) (SourceRepo, bool) {
+	repo := svc.Sources
+	if repo.URL == "" {
+		repo.URL = svc.ImageSources.URL
+	}
+	if repo.Revision == "" {
+		repo.Revision = svc.ImageSources.Revision
+	}
+	if repo.URL == "" {
+		repo.URL = job.GitRepo
+	}
+	if repo.URL == "" {
+		return repo, false
+	}
+	if repo.Revision == "" && repo.URL == job.GitRepo {
+		repo.Revision = job.HeadRevision
+	}
+	if repo.Revision == "" {
+		repo.Revision = "HEAD"
+	}
+	return repo, true
+}
+
+// checkoutSources checks out the source roots of the repositories at their revisions into the source dirs they are keyed by,
+// keeping the files of the packages selected by the plan includes and excludes.
+// Each revision is checked out once for its roots and filters, and then taken from the extract cache.
+func checkoutSources(repos map[string]SourceRepo, includes string, excludes string) error {
+	cache, err := getClassCache()
+	if err != nil {
+		return fmt.Errorf("open extract cache error %v", err)
+	}
+
+	var sources = map[string]SourceRepo{}
+	var sourceDirs = map[string][]string{}
+	for sourceDir, source := range repos {
+		sources[source.key()] = source
+		sourceDirs[source.key()] = append(sourceDirs[source.key()], sourceDir)
+	}
+
+	var errs []string
+	for key, source := range sources {
+		if err := checkoutSource(cache, source, includes, excludes, sourceDirs[key]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func checkoutSource(cache *extractcache.Cache, source SourceRepo, includes string, excludes string, sourceDirs []string) error {
+	repo, err := gitrepo.Sync(source.URL, GenGitRepoDir(source.URL))
+	if err != nil {
+		return err
+	}
+	commit, err := repo.ResolveRevision(source.Revision)
+	if err != nil {
+		return err
+	}
+	key := extractcache.Key(extractCacheVersion, "git", source.URL, commit, strings.Join(source.roots(), ","), includes, excludes)
+
+	entry, release, hit, err := cache.Get(key, func(dir string) error {
+		return archiveSources(repo, commit, source.roots(), extractor.NewFilter(includes, excludes), dir)
+	})
+	if err != nil {
+		return fmt.Errorf("checkout %v revision %v error %v", source.URL, source.Revision, err)
+	}
+	defer release()
+	log.Infof("sources of %v revision %v checked out, cache hit %v", source.URL, commit, hit)
+	for _, sourceDir := range sourceDirs {
+		if err := extractcache.LinkTree(entry, sourceDir); err != nil {
+			return fmt.Errorf("link sources of %v error %v", source.URL, err)
+		}
+	}
+	return nil
+}
+
+// archiveSources writes the files under the source roots of a commit into dir by their path relative to the root
+func archiveSources(repo *gitrepo.Repo, commit string, roots []string, filter *extractor.Filter, dir string) error {
+	var patterns []*regexp.Regexp
+	for _, root := range roots {
+		patterns = append(patterns, globToRegexp(strings.Trim(root, "/")))
+	}
+	return repo.Archive(commit, func(name string) (string, bool) {
+		rel, ok := relToSourceRoot(name, patterns)
+		if !ok || !filter.Match(path.Dir(rel)) {
+			return "", false
+		}
+		return filepath.Join(dir, filepath.FromSlash(rel)), true
+	})
+}
+
+// relToSourceRoot returns the path of a file relative to the innermost dir matching a source root
+func relToSourceRoot(name string, patterns []*regexp.Regexp) (string, bool) {
+	for i := strings.LastIndex(name, "/"); i > 0; i = strings.LastIndex(name[:i], "/") {
+		for _, pattern := range patterns {
+			if pattern.MatchString(name[:i]) {
+				return name[i+1:], true
+			}
+		}
+	}
+	return "", false
+}
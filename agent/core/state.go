@@ -0,0 +1,306 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/martian/log"
+)
+
+const (
+	callbackKindReady  = "ready"
+	callbackKindEnd    = "end"
+	callbackKindReport = "report"
+)
+
+// agentState is persisted on the work dir volume, so that a restarted agent resumes the running plan
+type agentState struct {
+	Jobs             []jobState        `json:"jobs"`
+	Services         []serviceState    `json:"services"`
+	PendingCallbacks []pendingCallback `json:"pendingCallbacks"`
+}
+
+type jobState struct {
+	PlanID        uint64                 `json:"planID"`
+	Status        CodeCoverageExecStatus `json:"status"`
+	JobStatus     string                 `json:"jobStatus"`
+	MavenSettings string                 `json:"mavenSettings"`
+	Includes      string                 `json:"includes"`
+	Excludes      string                 `json:"excludes"`
+	GitRepo       string                 `json:"gitRepo"`
+	BaseRevision  string                 `json:"baseRevision"`
+	HeadRevision  string                 `json:"headRevision"`
+	Diff          string                 `json:"diff"`
+	ReportFormats []string               `json:"reportFormats"`
+	ErrorMsg      string                 `json:"errorMsg"`
+	LatestExec    string                 `json:"latestExec"`
+	LatestReport  *ProjectReport         `json:"latestReport"`
+}
+
+type serviceState struct {
+	Name         string            `json:"name"`
+	Image        string            `json:"image"`
+	Endpoints    []Endpoint        `json:"endpoints"`
+	Artifacts    ArtifactSearch    `json:"artifacts"`
+	Sources      SourceRepo        `json:"sources"`
+	ImageSources SourceRepo        `json:"imageSources"`
+	JarAddrList  []string          `json:"jarAddrList"`
+	ExtractKeys  map[string]string `json:"extractKeys,omitempty"`
+	Pods         []Pod             `json:"pods"`
+	ErrorMessage string            `json:"errorMessage"`
+}
+
+// pendingCallback is a callback the center failed to receive, it is sent again until it succeeds
+type pendingCallback struct {
+	Kind   string                 `json:"kind"`
+	PlanID uint64                 `json:"planID"`
+	Ready  *CallbackRequest       `json:"ready,omitempty"`
+	End    *CallbackEndRequest    `json:"end,omitempty"`
+	Report *CallbackReportRequest `json:"report,omitempty"`
+}
+
+var stateLock sync.Mutex
+var pendingCallbacks = map[string]pendingCallback{}
+
+// the state is not saved until it is loaded, so a partly restored state does not replace the file
+var stateLoaded bool
+
+// withStateLock runs fn under the state lock, the jobs and services changed in place are changed in it
+// so that the api and the state file never read them half changed
+func withStateLock(fn func()) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	fn()
+}
+
+// saveState writes the jobs, services and pending callbacks into the state file
+func saveState() {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	if !stateLoaded {
+		return
+	}
+
+	var state agentState
+	RunJobs.Range(func(key, value interface{}) bool {
+		job := value.(*DetectionJob)
+		state.Jobs = append(state.Jobs, jobState{
+			PlanID:        job.PlanID,
+			Status:        job.Status,
+			JobStatus:     job.JobStatus,
+			MavenSettings: job.MavenSettings,
+			Includes:      job.Includes,
+			Excludes:      job.Excludes,
+			GitRepo:       job.GitRepo,
+			BaseRevision:  job.BaseRevision,
+			HeadRevision:  job.HeadRevision,
+			Diff:          job.Diff,
+			ReportFormats: job.ReportFormats,
+			ErrorMsg:      job.ErrorMsg,
+			LatestExec:    job.LatestExec,
+			LatestReport:  job.LatestReport,
+		})
+		return true
+	})
+	Services.Range(func(key, value interface{}) bool {
+		svc := value.(*Service)
+		if svc.IsDelete {
+			return true
+		}
+		state.Services = append(state.Services, serviceState{
+			Name:         svc.Name,
+			Image:        svc.Image,
+			Endpoints:    svc.Endpoints,
+			Artifacts:    svc.Artifacts,
+			Sources:      svc.Sources,
+			ImageSources: svc.ImageSources,
+			JarAddrList:  svc.JarAddrList,
+			ExtractKeys:  svc.ExtractKeys,
+			Pods:         svc.Pods,
+			ErrorMessage: svc.ErrorMessage,
+		})
+		return true
+	})
+	for _, callback := range pendingCallbacks {
+		state.PendingCallbacks = append(state.PendingCallbacks, callback)
+	}
+	sort.Slice(state.Jobs, func(i, j int) bool { return state.Jobs[i].PlanID < state.Jobs[j].PlanID })
+	sort.Slice(state.Services, func(i, j int) bool { return state.Services[i].Name < state.Services[j].Name })
+	sort.Slice(state.PendingCallbacks, func(i, j int) bool {
+		return pendingCallbackKey(state.PendingCallbacks[i].Kind, state.PendingCallbacks[i].PlanID) <
+			pendingCallbackKey(state.PendingCallbacks[j].Kind, state.PendingCallbacks[j].PlanID)
+	})
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Errorf("json marshal agent state error %v", err)
+		return
+	}
+	// write and rename, a restart while writing must not leave a broken state file
+	tempFile := GenStateAddr() + ".tmp"
+	err = ioutil.WriteFile(tempFile, data, 0644)
+	if err == nil {
+		err = os.Rename(tempFile, GenStateAddr())
+	}
+	if err != nil {
+		log.Errorf("write agent state error %v", err)
+	}
+}
+
+// LoadState restores the state saved before a restart, it must be called before the watchers start.
+// Services are only restored when their jars are still on the volume, so they are not extracted again,
+// and the running plans continue dumping into the exec files they already have.
+func LoadState() error {
+	defer func() {
+		stateLock.Lock()
+		stateLoaded = true
+		stateLock.Unlock()
+		saveState()
+	}()
+
+	data, err := ioutil.ReadFile(GenStateAddr())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read agent state error %v", err)
+	}
+
+	var state agentState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("parse agent state error %v", err)
+	}
+
+	for i := range state.Services {
+		restoreService(&state.Services[i])
+	}
+
+	stateLock.Lock()
+	for _, callback := range state.PendingCallbacks {
+		pendingCallbacks[pendingCallbackKey(callback.Kind, callback.PlanID)] = callback
+	}
+	stateLock.Unlock()
+
+	for i := range state.Jobs {
+		restoreJob(&state.Jobs[i])
+	}
+	return nil
+}
+
+func restoreService(state *serviceState) {
+	if len(state.JarAddrList) <= 0 {
+		return
+	}
+	for _, jarAddr := range state.JarAddrList {
+		if !Exists(jarAddr) {
+			log.Infof("svc %v jar %v not found, load it again", state.Name, jarAddr)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	SetService(state.Name, &Service{
+		Name:         state.Name,
+		Image:        state.Image,
+		Endpoints:    state.Endpoints,
+		Artifacts:    state.Artifacts,
+		Sources:      state.Sources,
+		ImageSources: state.ImageSources,
+		JarAddrList:  state.JarAddrList,
+		ExtractKeys:  state.ExtractKeys,
+		Pods:         state.Pods,
+		ErrorMessage: state.ErrorMessage,
+		ctx:          ctx,
+		cancelFunc:   cancel,
+	})
+	log.Infof("restore svc %v", state.Name)
+}
+
+func restoreJob(state *jobState) {
+	switch state.Status {
+	case SuccessStatus, FailStatus, CancelStatus:
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &DetectionJob{
+		PlanID:        state.PlanID,
+		Status:        state.Status,
+		JobStatus:     state.JobStatus,
+		MavenSettings: state.MavenSettings,
+		Includes:      state.Includes,
+		Excludes:      state.Excludes,
+		GitRepo:       state.GitRepo,
+		BaseRevision:  state.BaseRevision,
+		HeadRevision:  state.HeadRevision,
+		Diff:          state.Diff,
+		ReportFormats: state.ReportFormats,
+		ErrorMsg:      state.ErrorMsg,
+		LatestExec:    state.LatestExec,
+		LatestReport:  state.LatestReport,
+		ctx:           ctx,
+		cancelFunc:    cancel,
+	}
+	if len(job.ReportFormats) <= 0 {
+		job.ReportFormats = defaultReportFormats
+	}
+
+	SetJob(job.PlanID, job)
+	setPlanStatus(job.PlanID, job.Status)
+	log.Infof("restore plan %v, job status %v", job.PlanID, job.JobStatus)
+	go schedulingJob(job.PlanID)
+}
+
+func pendingCallbackKey(kind string, planID uint64) string {
+	return fmt.Sprintf("%v/%v", planID, kind)
+}
+
+// setPendingCallback keeps the callback of the plan to be sent again, or removes it once it is received
+func setPendingCallback(callback pendingCallback, err error) {
+	stateLock.Lock()
+	key := pendingCallbackKey(callback.Kind, callback.PlanID)
+	_, pending := pendingCallbacks[key]
+	if err != nil {
+		pendingCallbacks[key] = callback
+	} else {
+		delete(pendingCallbacks, key)
+	}
+	stateLock.Unlock()
+
+	if err != nil || pending {
+		saveState()
+	}
+}
+
+// resendPendingCallbacks sends the callbacks the center failed to receive again
+func resendPendingCallbacks() {
+	stateLock.Lock()
+	var callbacks []pendingCallback
+	for _, callback := range pendingCallbacks {
+		callbacks = append(callbacks, callback)
+	}
+	stateLock.Unlock()
+
+	for _, callback := range callbacks {
+		var err error
+		switch callback.Kind {
+		case callbackKindReady:
+			err = center.CallbackReady(callback.Ready)
+		case callbackKindEnd:
+			err = center.CallbackEnd(callback.End)
+		case callbackKindReport:
+			err = center.CallbackReport(callback.Report)
+		}
+		observeCallback(callback.Kind, err)
+		if err != nil {
+			log.Errorf("resend plan %v %v callback error %v", callback.PlanID, callback.Kind, err)
+		}
+		setPendingCallback(callback, err)
+	}
+}
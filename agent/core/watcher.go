@@ -17,8 +17,10 @@ import (
 	"github.com/google/martian/log"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1opt "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
@@ -27,36 +29,55 @@ import (
 	"k8s.io/client-go/util/retry"
 
 	"github.com/erda-project/erda-sourcecov/agent/conf"
+	"github.com/erda-project/erda-sourcecov/agent/pkg/jacoco"
 )
 
 var restClient *restclient.Config
 var clientSet *kubernetes.Clientset
 
 type Service struct {
-	Name               string
-	Image              string
-	JarAddrList        []string
-	Pods               []Pod
-	LoadJarPackageLock sync.Mutex
-	ErrorMessage       string
+	Name  string
+	Image string
+	// jvms of the pods and where their artifacts are in the containers
+	Endpoints []Endpoint
+	Artifacts ArtifactSearch
+	// sources named by the annotations, and by the labels of the image the artifacts are pulled from
+	Sources      SourceRepo
+	ImageSources SourceRepo
+	JarAddrList  []string
+	// extract cache keys of the artifacts by path, of the classes last extracted into the class dir
+	ExtractKeys  map[string]string
+	Pods         []Pod
+	ErrorMessage string
 
 	IsDelete   bool
 	ctx        context.Context
 	cancelFunc func()
 }
 
+// Endpoint is a jvm of a pod, the container it runs in and the tcpserver port of its jacoco agent
+type Endpoint struct {
+	Container string
+	Port      int
+}
+
 type Pod struct {
 	Addr          string
 	PodName       string
 	ContainerName string
+	Endpoints     []Endpoint
 	ErrorMsg      string
 	HasError      bool
+	// dump bookkeeping, kept across agent restarts as every dump resets the pod
+	Dumps      int
+	LastDumpAt time.Time
 }
 
 var Services = sync.Map{}
 
 func SetService(svcName string, svc *Service) {
 	Services.Store(svcName, svc)
+	saveState()
 }
 
 func GetService(svcName string) (*Service, bool) {
@@ -69,7 +90,8 @@ func GetService(svcName string) (*Service, bool) {
 }
 
 func DeleteService(svcName string) {
-	RunJobs.Delete(svcName)
+	Services.Delete(svcName)
+	saveState()
 }
 
 func setK8sClientSet() error {
@@ -103,8 +125,17 @@ func setK8sClientSet() error {
 }
 
 var WhenStartLoadAllDeploymentLock sync.Mutex
-var deployListNum int
-var watchListDeployLoadDoneNum = 0
+
+// workloads are the kinds of pod controllers whose pods are coverage targets
+var workloads = []struct {
+	resource string
+	objType  runtime.Object
+	client   func() cache.Getter
+}{
+	{"deployments", &v1.Deployment{}, func() cache.Getter { return clientSet.AppsV1().RESTClient() }},
+	{"statefulsets", &v1.StatefulSet{}, func() cache.Getter { return clientSet.AppsV1().RESTClient() }},
+	{"daemonsets", &v1.DaemonSet{}, func() cache.Getter { return clientSet.AppsV1().RESTClient() }},
+}
 
 func WatchJacocoPod(ctx context.Context) {
 	WhenStartLoadAllDeploymentLock.Lock()
@@ -118,80 +149,47 @@ func WatchJacocoPod(ctx context.Context) {
 			return
 		}
 
-		list, err := clientSet.AppsV1().Deployments(conf.Cfg.ProjectNs).List(ctx, v1opt.ListOptions{})
+		// preload all services at initialization, with the names of all targets in the namespace
+		var controllers []cache.Controller
+		var live = map[string]bool{}
+		for _, w := range workloads {
+			watchlist := cache.NewListWatchFromClient(w.client(), w.resource, conf.Cfg.ProjectNs, fields.Everything())
+			list, err := watchlist.List(v1opt.ListOptions{})
+			if err == nil {
+				err = preloadServices(ctx, list, live)
+			}
+			if err != nil {
+				WhenStartLoadAllDeploymentLock.Unlock()
+				log.Errorf("get ns %v list error %v", w.resource, err)
+				return
+			}
+			controllers = append(controllers, newWorkloadController(ctx, watchlist, w.objType))
+		}
+		pods, err := clientSet.CoreV1().Pods(conf.Cfg.ProjectNs).List(ctx, v1opt.ListOptions{})
 		if err != nil {
 			WhenStartLoadAllDeploymentLock.Unlock()
-			log.Errorf("get ns deploy list error %v", err)
+			log.Errorf("get ns pod list error %v", err)
 			return
 		}
-
-		// preload all services at initialization
-		for i := range list.Items {
-			newServices := getServiceByDeploy(ctx, &list.Items[i])
+		for i := range pods.Items {
+			if name, err := serviceNameOf(&pods.Items[i], pods.Items[i].Annotations); err == nil && isBarePod(&pods.Items[i]) {
+				live[name] = true
+			}
+			newServices := getServiceByPod(&pods.Items[i])
 			if newServices != nil {
-				log.Infof("preload service %v", *newServices)
+				log.Infof("preload service %v", newServices.Name)
 			}
 			saveServices(newServices, true)
 		}
+		deleteMissingServices(live)
 		WhenStartLoadAllDeploymentLock.Unlock()
 
-		deployListNum = len(list.Items)
-
-		watchlist := cache.NewListWatchFromClient(
-			clientSet.AppsV1().RESTClient(),
-			"deployments", conf.Cfg.ProjectNs,
-			fields.Everything())
-
-		_, controller := cache.NewInformer(
-			watchlist,
-			&v1.Deployment{},
-			0, //Duration is int64
-			cache.ResourceEventHandlerFuncs{
-				AddFunc: func(obj interface{}) {
-					deploy, ok := obj.(*v1.Deployment)
-					if ok {
-						newServices := getServiceByDeploy(ctx, deploy)
-						if watchListDeployLoadDoneNum < deployListNum {
-							saveServices(newServices, true)
-						} else {
-							saveServices(newServices, false)
-						}
-
-						watchListDeployLoadDoneNum++
-					} else {
-						watchListDeployLoadDoneNum++
-						log.Errorf("not a v1.Deployment type")
-						jsn, _ := json.Marshal(obj)
-						log.Infof("Deployment added: %s\n", jsn)
-					}
-				},
-				DeleteFunc: func(obj interface{}) {
-					deploy, ok := obj.(*v1.Deployment)
-					if ok {
-						deleteService(deploy.Name)
-					} else {
-						log.Errorf("not a v1.Deployment type")
-						jsn, _ := json.Marshal(obj)
-						log.Infof("Deployment added: %s\n", jsn)
-					}
-				},
-				UpdateFunc: func(oldObj, newObj interface{}) {
-					deploy, ok := newObj.(*v1.Deployment)
-					if ok {
-						newServices := getServiceByDeploy(ctx, deploy)
-						saveServices(newServices, false)
-					} else {
-						log.Errorf("not a v1.Deployment type")
-						jsn, _ := json.Marshal(newObj)
-						log.Infof("Deployment added: %s\n", jsn)
-					}
-				},
-			},
-		)
-
 		stop := make(chan struct{})
 		defer close(stop)
-		go controller.Run(stop)
+		for _, controller := range controllers {
+			go controller.Run(stop)
+		}
+		go newPodController().Run(stop)
 
 		select {
 		case <-ctx.Done():
@@ -200,59 +198,232 @@ func WatchJacocoPod(ctx context.Context) {
 	}()
 }
 
-func getServiceByDeploy(ctx context.Context, deploy *v1.Deployment) *Service {
-	if deploy == nil {
-		return nil
+func preloadServices(ctx context.Context, list runtime.Object, live map[string]bool) error {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
 	}
-
-	var newServices = Service{Name: deploy.Labels["app"]}
-	for _, container := range deploy.Spec.Template.Spec.Containers {
-		for _, env := range container.Env {
-			if env.Name == "OPEN_JACOCO_AGENT" && env.Value == "true" {
-				newServices.Image = container.Image
-				break
+	for _, item := range items {
+		if workload, template, _, ok := podTemplateOf(item); ok {
+			if name, err := serviceNameOf(workload, mergeAnnotations(workload, template)); err == nil {
+				live[name] = true
 			}
-			if env.Name == "SOURCECOV_ENABLED" && env.Value == "true" {
-				newServices.Image = container.Image
-				break
+		}
+		newServices := getServiceByWorkload(ctx, item)
+		if newServices != nil {
+			log.Infof("preload service %v", newServices.Name)
+		}
+		saveServices(newServices, true)
+	}
+	return nil
+}
+
+func newWorkloadController(ctx context.Context, watchlist cache.ListerWatcher, objType runtime.Object) cache.Controller {
+	_, controller := cache.NewInformer(
+		watchlist,
+		objType,
+		0, //Duration is int64
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				saveServices(getServiceByWorkload(ctx, obj), false)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				workload, template, _, ok := podTemplateOf(obj)
+				if !ok {
+					jsn, _ := json.Marshal(obj)
+					log.Errorf("not a workload type: %s", jsn)
+					return
+				}
+				if name, err := serviceNameOf(workload, mergeAnnotations(workload, template)); err == nil {
+					deleteService(name)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				saveServices(getServiceByWorkload(ctx, newObj), false)
+			},
+		},
+	)
+	return controller
+}
+
+// podTemplateOf returns the workload object, the template of its pods and its pod selector
+func podTemplateOf(obj interface{}) (v1opt.Object, *corev1.PodTemplateSpec, *v1opt.LabelSelector, bool) {
+	switch workload := obj.(type) {
+	case *v1.Deployment:
+		return workload, &workload.Spec.Template, workload.Spec.Selector, true
+	case *v1.StatefulSet:
+		return workload, &workload.Spec.Template, workload.Spec.Selector, true
+	case *v1.DaemonSet:
+		return workload, &workload.Spec.Template, workload.Spec.Selector, true
+	}
+	return nil, nil, nil, false
+}
+
+// newPodController watches the pods of the namespace: a pod entering terminating is dumped at once,
+// as the workload is not updated when its pods are deleted, and bare pods are services of their own
+func newPodController() cache.Controller {
+	watchlist := cache.NewListWatchFromClient(
+		clientSet.CoreV1().RESTClient(),
+		"pods", conf.Cfg.ProjectNs,
+		fields.Everything())
+
+	_, controller := cache.NewInformer(
+		watchlist,
+		&corev1.Pod{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				pod, ok := obj.(*corev1.Pod)
+				if ok {
+					saveServices(getServiceByPod(pod), false)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				pod, ok := obj.(*corev1.Pod)
+				if ok && isBarePod(pod) {
+					if name, err := serviceNameOf(pod, pod.Annotations); err == nil {
+						deleteService(name)
+					}
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPod, ok := oldObj.(*corev1.Pod)
+				if !ok {
+					return
+				}
+				newPod, ok := newObj.(*corev1.Pod)
+				if !ok {
+					log.Errorf("not a v1.Pod type")
+					return
+				}
+				if oldPod.DeletionTimestamp == nil && newPod.DeletionTimestamp != nil {
+					go dumpTerminatingPod(newPod.Name)
+					return
+				}
+				saveServices(getServiceByPod(newPod), false)
+			},
+		},
+	)
+	return controller
+}
+
+// findServicePod returns the service the pod belongs to
+func findServicePod(podName string) (*Service, Pod, bool) {
+	var svc *Service
+	var pod Pod
+	Services.Range(func(key, value interface{}) bool {
+		s := value.(*Service)
+		if s.IsDelete {
+			return true
+		}
+		for _, p := range s.Pods {
+			if p.PodName == podName {
+				svc = s
+				pod = p
+				return false
 			}
 		}
+		return true
+	})
+	return svc, pod, svc != nil
+}
+
+func newServiceOf(target *target, pods []Pod) *Service {
+	var newServices = Service{
+		Name:      target.name,
+		Image:     target.image,
+		Endpoints: target.endpoints,
+		Artifacts: target.artifacts,
+		Sources:   target.sources,
+		Pods:      pods,
 	}
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	newServices.ctx = cancelCtx
+	newServices.cancelFunc = cancelFunc
+	return &newServices
+}
 
-	if newServices.Image == "" {
+func getServiceByWorkload(ctx context.Context, obj interface{}) *Service {
+	workload, template, labelSelector, ok := podTemplateOf(obj)
+	if !ok {
+		return nil
+	}
+
+	target, err := targetOf(workload, mergeAnnotations(workload, template), &template.Spec)
+	if err != nil {
+		log.Errorf("workload %v coverage annotations error %v", workload.GetName(), err)
+		return nil
+	}
+	if target == nil {
+		return nil
+	}
+
+	selector, err := v1opt.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		log.Errorf("workload %v selector error %v", workload.GetName(), err)
 		return nil
 	}
 
-	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	var pods []Pod
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		k8sPods, err := clientSet.CoreV1().Pods(conf.Cfg.ProjectNs).List(ctx, v1opt.ListOptions{
-			LabelSelector: "app=" + newServices.Name,
+			LabelSelector: selector.String(),
 		})
 		if err != nil {
 			return err
 		}
 
-		var pods []Pod
+		pods = nil
 		for _, pod := range k8sPods.Items {
 			pods = append(pods, Pod{
 				Addr:          pod.Status.PodIP,
 				PodName:       pod.Name,
-				ContainerName: pod.Spec.Containers[0].Name,
+				ContainerName: target.endpoints[0].Container,
+				Endpoints:     target.endpoints,
 			})
 		}
-		newServices.Pods = pods
 		return nil
 	})
 	if err != nil {
-		log.Errorf("can not get deployment pods, err %v", err)
+		log.Errorf("can not get %v pods, err %v", target.name, err)
 		return nil
 	}
 
-	cancelCtx, cancelFunc := context.WithCancel(context.Background())
-	newServices.ctx = cancelCtx
-	newServices.cancelFunc = cancelFunc
-	newServices.LoadJarPackageLock = sync.Mutex{}
+	return newServiceOf(target, pods)
+}
 
-	return &newServices
+// isBarePod reports whether the pod is not managed by a controller
+func isBarePod(pod *corev1.Pod) bool {
+	return v1opt.GetControllerOf(pod) == nil
+}
+
+// getServiceByPod returns the service of a bare pod enabled for coverage, the pods of workloads
+// are part of the service of their workload
+func getServiceByPod(pod *corev1.Pod) *Service {
+	if pod == nil || !isBarePod(pod) || pod.DeletionTimestamp != nil {
+		return nil
+	}
+	target, err := targetOf(pod, pod.Annotations, &pod.Spec)
+	if err != nil {
+		log.Errorf("pod %v coverage annotations error %v", pod.Name, err)
+		return nil
+	}
+	if target == nil || pod.Status.PodIP == "" {
+		return nil
+	}
+
+	return newServiceOf(target, []Pod{{
+		Addr:          pod.Status.PodIP,
+		PodName:       pod.Name,
+		ContainerName: target.endpoints[0].Container,
+		Endpoints:     target.endpoints,
+	}})
 }
 
 func saveServices(svc *Service, sync bool) {
@@ -294,11 +465,24 @@ func saveServices(svc *Service, sync bool) {
 			}
 		}
 	} else {
-		oldSvc.Pods = svc.Pods
+		var reload bool
+		var removed []string
+		withStateLock(func() {
+			removed = removedPods(oldSvc.Pods, svc.Pods)
+			oldSvc.Pods = keepPodDumpState(oldSvc.Pods, svc.Pods)
+			oldSvc.Endpoints = svc.Endpoints
+			oldSvc.Sources = svc.Sources
+			if oldSvc.Image != svc.Image || !oldSvc.Artifacts.equal(svc.Artifacts) {
+				oldSvc.Image = svc.Image
+				oldSvc.Artifacts = svc.Artifacts
+				reload = true
+			}
+		})
 		SetService(svc.Name, oldSvc)
-		if oldSvc.Image != svc.Image {
-			oldSvc.Image = svc.Image
-			SetService(svc.Name, oldSvc)
+		for _, podName := range removed {
+			deletePodMetrics(svc.Name, podName)
+		}
+		if reload {
 			go func() {
 				err := reloadJarAddr(svc)
 				if err != nil {
@@ -311,11 +495,63 @@ func saveServices(svc *Service, sync bool) {
 	return
 }
 
+// endpoints returns the jvms of the pod, the default port in its container if they are not set
+func (p Pod) endpoints() []Endpoint {
+	if len(p.Endpoints) > 0 {
+		return p.Endpoints
+	}
+	return []Endpoint{{Container: p.ContainerName, Port: jacoco.DefaultPort}}
+}
+
+// removedPods returns the names of the old pods not in the new pods
+func removedPods(oldPods []Pod, newPods []Pod) []string {
+	var newPodMap = map[string]bool{}
+	for _, pod := range newPods {
+		newPodMap[pod.PodName] = true
+	}
+	var removed []string
+	for _, pod := range oldPods {
+		if !newPodMap[pod.PodName] {
+			removed = append(removed, pod.PodName)
+		}
+	}
+	return removed
+}
+
+// keepPodDumpState copies the errors and dump bookkeeping of the pods still running into the new pods
+func keepPodDumpState(oldPods []Pod, newPods []Pod) []Pod {
+	var oldPodMap = map[string]Pod{}
+	for _, pod := range oldPods {
+		oldPodMap[pod.PodName] = pod
+	}
+	for i := range newPods {
+		oldPod, ok := oldPodMap[newPods[i].PodName]
+		if !ok || oldPod.Addr != newPods[i].Addr {
+			continue
+		}
+		newPods[i].ErrorMsg = oldPod.ErrorMsg
+		newPods[i].HasError = oldPod.HasError
+		newPods[i].Dumps = oldPod.Dumps
+		newPods[i].LastDumpAt = oldPod.LastDumpAt
+	}
+	return newPods
+}
+
+// the jar locks by service name, the jars of a service are replaced by its reloads and read by the extraction of a plan,
+// the services of the updates are new objects each time, so the lock is not kept in them
+var serviceJarLocks = sync.Map{}
+
+func lockServiceJars(svcName string) func() {
+	value, _ := serviceJarLocks.LoadOrStore(svcName, &sync.Mutex{})
+	lock := value.(*sync.Mutex)
+	lock.Lock()
+	return lock.Unlock
+}
+
 func reloadJarAddr(svc *Service) error {
-	svc.LoadJarPackageLock.Lock()
-	defer svc.LoadJarPackageLock.Unlock()
+	defer lockServiceJars(svc.Name)()
 
-	jarList, err := getServiceJarPackage(svc)
+	jarList, imageSources, err := getServiceJarPackage(svc)
 
 	service, ok := GetService(svc.Name)
 	if !ok {
@@ -326,48 +562,111 @@ func reloadJarAddr(svc *Service) error {
 		errorMessage := fmt.Errorf("get svc %v jar package error %v", svc.Name, err)
 		log.Errorf(errorMessage.Error())
 
-		service.ErrorMessage = errorMessage.Error()
+		withStateLock(func() {
+			service.ErrorMessage = errorMessage.Error()
+		})
 		SetService(svc.Name, service)
 		return err
 	}
 
-	service.JarAddrList = jarList
+	withStateLock(func() {
+		service.JarAddrList = jarList
+		service.ImageSources = imageSources
+	})
 	SetService(svc.Name, service)
 	return nil
 }
 
-func getServiceJarPackage(svc *Service) ([]string, error) {
+// getServiceJarPackage returns the artifacts of a service, with the sources named by the labels of its image
+func getServiceJarPackage(svc *Service) ([]string, SourceRepo, error) {
 	log.Infof("start get svc %v jar package", svc.Name)
 	defer log.Infof("end get svc %v jar package", svc.Name)
 
-	imageJarTempPath, err := GenSvcJarImageTempDir(svc.Name)
-	if err != nil {
-		return nil, err
+	if len(svc.Pods) <= 0 {
+		return nil, SourceRepo{}, fmt.Errorf("svc %v has no pod", svc.Name)
 	}
 
-	err = copyFromPod(svc.Pods[0].PodName, "/app", imageJarTempPath+"/app")
+	// the jars are kept on the work dir volume, so they are not copied again after a restart.
+	// They are pulled into a temp dir next to the jar dir, and moved into place once all are pulled.
+	jarDir := GenSvcJarDir(svc.Name)
+	tempPattern := "." + svc.Name + "_pull_*"
+	if leftovers, err := filepath.Glob(filepath.Join(filepath.Dir(jarDir), tempPattern)); err == nil {
+		for _, leftover := range leftovers {
+			os.RemoveAll(leftover)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(jarDir), 0755); err != nil {
+		return nil, SourceRepo{}, err
+	}
+	imageJarTempPath, err := os.MkdirTemp(filepath.Dir(jarDir), tempPattern)
 	if err != nil {
-		log.Errorf("get pod jar path %v error %v", "/app", err)
-		return nil, err
+		return nil, SourceRepo{}, err
 	}
+	defer os.RemoveAll(imageJarTempPath)
+
+	// the artifacts of each container running a jvm, pulled from the image of the container,
+	// or copied out of the container if the image can not be pulled
 	var jarAddrList []string
-	err = filepath.Walk(imageJarTempPath+"/app", func(path string, info os.FileInfo, err error) error {
+	var imageSources SourceRepo
+	var containers = map[string]bool{}
+	for _, endpoint := range svc.Pods[0].endpoints() {
+		if containers[endpoint.Container] {
+			continue
+		}
+		containers[endpoint.Container] = true
+
+		roots := svc.Artifacts.roots()
+		dirs := imageRootDirs(path.Join(imageJarTempPath, endpoint.Container), roots)
+		labels, err := pullFromImage(svc.Pods[0].PodName, endpoint.Container, dirs, svc.Artifacts.Excludes)
+		if imageSources.URL == "" && imageSources.Revision == "" {
+			imageSources = sourceRepoOfLabels(labels)
+		}
 		if err != nil {
-			return nil
+			log.Errorf("pull pod %v container %v image error %v, copy from container instead", svc.Pods[0].PodName, endpoint.Container, err)
+			for _, root := range roots {
+				os.RemoveAll(dirs[root])
+				err = copyFromPod(svc.Pods[0].PodName, endpoint.Container, root, dirs[root], svc.Artifacts.Excludes)
+				if err != nil {
+					log.Errorf("get pod container %v jar path %v error %v", endpoint.Container, root, err)
+					return nil, SourceRepo{}, err
+				}
+			}
 		}
-		if !info.IsDir() && strings.HasSuffix(path, ".jar") {
-			jarAddrList = append(jarAddrList, path)
+		for _, root := range roots {
+			artifacts, err := findArtifacts(dirs[root], svc.Artifacts)
+			if err != nil {
+				return nil, SourceRepo{}, err
+			}
+			jarAddrList = append(jarAddrList, artifacts...)
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
+	}
+	if len(jarAddrList) <= 0 {
+		return nil, SourceRepo{}, fmt.Errorf("no artifact found in %v", strings.Join(svc.Artifacts.roots(), ","))
 	}
 
-	return jarAddrList, nil
+	for i, jarAddr := range jarAddrList {
+		rel, err := filepath.Rel(imageJarTempPath, jarAddr)
+		if err != nil {
+			return nil, SourceRepo{}, err
+		}
+		jarAddrList[i] = filepath.Join(jarDir, rel)
+	}
+	if err := os.RemoveAll(jarDir); err != nil {
+		return nil, SourceRepo{}, err
+	}
+	if err := os.Rename(imageJarTempPath, jarDir); err != nil {
+		return nil, SourceRepo{}, err
+	}
+	return jarAddrList, imageSources, nil
 }
 
-func copyFromPod(podName string, srcPath string, destPath string) error {
+func copyFromPod(podName string, containerName string, srcPath string, destPath string, excludes []string) error {
+	var command = []string{"tar", "cf", "-"}
+	for _, exclude := range excludes {
+		command = append(command, "--exclude="+exclude)
+	}
+	command = append(command, srcPath)
+
 	r := restClient
 	c := clientSet
 
@@ -378,8 +677,9 @@ func copyFromPod(podName string, srcPath string, destPath string) error {
 		Namespace(conf.Cfg.ProjectNs).
 		SubResource("exec").
 		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
 			// 将数据转换成数据流
-			Command: []string{"tar", "cf", "-", srcPath},
+			Command: command,
 			Stdin:   true,
 			Stdout:  true,
 			Stderr:  true,
@@ -502,22 +802,6 @@ func getPrefix(file string) string {
 	return strings.TrimLeft(file, "/")
 }
 
-func unTarImage(unTarDir string, rootTar string, layers []string) error {
-	err := simpleRun("", "tar", "-xf", rootTar, "-C", unTarDir)
-	if err != nil {
-		return err
-	}
-	var errInfo error
-	for _, layer := range layers {
-		err = simpleRun("", "tar", "-zxf", fmt.Sprintf("%v/%v.tar.gz", unTarDir, layer), "-C", unTarDir)
-		if err != nil {
-			errInfo = err
-		}
-	}
-	fmt.Println("tar zxf error", errInfo)
-	return nil
-}
-
 func simpleRun(dir string, name string, arg ...string) error {
 	fmt.Fprintf(os.Stdout, "Run: %s, %v\n", name, arg)
 	cmd := exec.Command(name, arg...)
@@ -529,11 +813,30 @@ func simpleRun(dir string, name string, arg ...string) error {
 	return cmd.Run()
 }
 
+// deleteMissingServices deletes the services whose workload or bare pod is not in the namespace any more,
+// as the services restored from the state of a workload deleted while the agent was down
+func deleteMissingServices(live map[string]bool) {
+	var missing []string
+	Services.Range(func(key, value interface{}) bool {
+		if !live[key.(string)] {
+			missing = append(missing, key.(string))
+		}
+		return true
+	})
+	for _, name := range missing {
+		log.Infof("svc %v not found in the namespace, delete it", name)
+		deleteService(name)
+	}
+}
+
 func deleteService(name string) {
 	svc, ok := GetService(name)
 	if ok {
-		svc.IsDelete = true
+		withStateLock(func() {
+			svc.IsDelete = true
+		})
 		svc.cancelFunc()
+		deleteServiceMetrics(svc)
 		SetService(name, svc)
 	}
 }
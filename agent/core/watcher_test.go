@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeepPodDumpState(t *testing.T) {
+	dumpedAt := time.Date(2021, 8, 1, 10, 0, 0, 0, time.UTC)
+	oldPods := []Pod{
+		{PodName: "order-1", Addr: "10.0.0.1", Dumps: 3, LastDumpAt: dumpedAt},
+		{PodName: "order-2", Addr: "10.0.0.2", ErrorMsg: "connection refused", HasError: true, Dumps: 1, LastDumpAt: dumpedAt},
+		{PodName: "order-3", Addr: "10.0.0.3", Dumps: 5, LastDumpAt: dumpedAt},
+	}
+	newPods := []Pod{
+		{PodName: "order-1", Addr: "10.0.0.1"},
+		// restarted with another address, it is a new jvm
+		{PodName: "order-2", Addr: "10.0.0.9"},
+		{PodName: "order-4", Addr: "10.0.0.4"},
+	}
+
+	got := keepPodDumpState(oldPods, newPods)
+	want := []Pod{
+		{PodName: "order-1", Addr: "10.0.0.1", Dumps: 3, LastDumpAt: dumpedAt},
+		{PodName: "order-2", Addr: "10.0.0.9"},
+		{PodName: "order-4", Addr: "10.0.0.4"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pods = %+v, want %+v", got, want)
+	}
+
+	if removed := removedPods(oldPods, newPods); !reflect.DeepEqual(removed, []string{"order-3"}) {
+		t.Errorf("removed pods = %v", removed)
+	}
+	if got := keepPodDumpState(nil, nil); got != nil {
+		t.Errorf("pods of no pods = %v", got)
+	}
+}
+
+func TestDeleteMissingServices(t *testing.T) {
+	for _, name := range []string{"order", "user"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		SetService(name, &Service{Name: name, ctx: ctx, cancelFunc: cancel})
+		defer DeleteService(name)
+	}
+
+	deleteMissingServices(map[string]bool{"order": true})
+	order, _ := GetService("order")
+	user, _ := GetService("user")
+	if order.IsDelete || !user.IsDelete {
+		t.Errorf("order deleted %v, user deleted %v", order.IsDelete, user.IsDelete)
+	}
+	if user.ctx.Err() == nil {
+		t.Errorf("user not canceled")
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const coberturaDocType = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity int               `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int    `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// WriteCobertura converts the jacoco report into cobertura xml. Classes are reported per source file,
+// with the classes declared in it merged, and file names relative to the source roots of the groups.
+// When the report has a group per service, the packages are reported per service and named after it,
+// as a class may be in two services.
+func WriteCobertura(w io.Writer, report *Report, sourceRoot func(group string) string) error {
+	var counters Counters
+	counters.add(report.Counters)
+	var complexity = counterOf(report.Counters, CounterComplexity)
+
+	var cobertura = coberturaCoverage{
+		LineRate:        rate(counters.Line),
+		BranchRate:      rate(counters.Branch),
+		LinesCovered:    counters.Line.Covered,
+		LinesValid:      counters.Line.Total(),
+		BranchesCovered: counters.Branch.Covered,
+		BranchesValid:   counters.Branch.Total(),
+		Complexity:      complexity.Total(),
+		Version:         "jacoco",
+		Timestamp:       reportTimestamp(report),
+	}
+
+	var grouped = len(report.Groups) > 0
+	_ = report.eachGroup(func(name string, groupReport *Report) error {
+		cobertura.Sources = append(cobertura.Sources, sourceRoot(name))
+		for _, pkg := range groupReport.AllPackages() {
+			var pkgCounters Counters
+			pkgCounters.add(pkg.Counters)
+			var coberturaPkg = coberturaPackage{
+				Name:       strings.ReplaceAll(pkg.Name, "/", "."),
+				LineRate:   rate(pkgCounters.Line),
+				BranchRate: rate(pkgCounters.Branch),
+				Complexity: counterOf(pkg.Counters, CounterComplexity).Total(),
+			}
+			if grouped && name != "" {
+				coberturaPkg.Name = name + ":" + coberturaPkg.Name
+			}
+
+			var classesByFile = map[string][]ClassNode{}
+			for _, class := range pkg.Classes {
+				classesByFile[class.SourceFileName] = append(classesByFile[class.SourceFileName], class)
+			}
+			for _, sourceFile := range pkg.SourceFiles {
+				coberturaPkg.Classes = append(coberturaPkg.Classes, coberturaClassOf(pkg.Name, sourceFile, classesByFile[sourceFile.Name]))
+			}
+			cobertura.Packages = append(cobertura.Packages, coberturaPkg)
+		}
+		return nil
+	})
+
+	if _, err := io.WriteString(w, xml.Header+coberturaDocType+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(cobertura); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func coberturaClassOf(pkgName string, sourceFile SourceFile, classes []ClassNode) coberturaClass {
+	var counters Counters
+	counters.add(sourceFile.Counters)
+
+	var className = strings.TrimSuffix(sourceFile.Name, ".java")
+	if pkgName != "" {
+		className = pkgName + "/" + className
+	}
+	var class = coberturaClass{
+		Name:       strings.ReplaceAll(className, "/", "."),
+		Filename:   sourceFilePath(pkgName, sourceFile.Name),
+		LineRate:   rate(counters.Line),
+		BranchRate: rate(counters.Branch),
+		Complexity: counterOf(sourceFile.Counters, CounterComplexity).Total(),
+	}
+
+	var lines = map[int]coberturaLine{}
+	for _, line := range sourceFile.Lines {
+		coberturaLine := coberturaLineOf(line)
+		lines[line.Nr] = coberturaLine
+		class.Lines = append(class.Lines, coberturaLine)
+	}
+
+	for _, classNode := range classes {
+		for _, method := range classNode.Methods {
+			var methodCounters Counters
+			methodCounters.add(method.Counters)
+			var coberturaMethod = coberturaMethod{
+				Name:       method.Name,
+				Signature:  method.Desc,
+				LineRate:   rate(methodCounters.Line),
+				BranchRate: rate(methodCounters.Branch),
+				Complexity: counterOf(method.Counters, CounterComplexity).Total(),
+			}
+			if line, ok := lines[method.Line]; ok {
+				coberturaMethod.Lines = append(coberturaMethod.Lines, line)
+			}
+			class.Methods = append(class.Methods, coberturaMethod)
+		}
+	}
+	sort.SliceStable(class.Methods, func(i, j int) bool {
+		return methodLine(class.Methods[i]) < methodLine(class.Methods[j])
+	})
+	return class
+}
+
+func coberturaLineOf(line LineNode) coberturaLine {
+	var coberturaLine = coberturaLine{Number: line.Nr}
+	if line.CI > 0 {
+		coberturaLine.Hits = 1
+	}
+	if branches := line.MB + line.CB; branches > 0 {
+		coberturaLine.Branch = true
+		coberturaLine.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", line.CB*100/branches, line.CB, branches)
+	}
+	return coberturaLine
+}
+
+func methodLine(method coberturaMethod) int {
+	if len(method.Lines) == 0 {
+		return 0
+	}
+	return method.Lines[0].Number
+}
+
+func counterOf(counters []Counter, counterType string) Counter {
+	for _, counter := range counters {
+		if counter.Type == counterType {
+			return counter
+		}
+	}
+	return Counter{Type: counterType}
+}
+
+func rate(counter Counter) string {
+	if counter.Total() == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(counter.Ratio(), 'f', 4, 64)
+}
+
+// reportTimestamp is the last dump time of the report sessions in seconds
+func reportTimestamp(report *Report) int64 {
+	var timestamp int64
+	for _, session := range report.Sessions {
+		if session.Dump/1000 > timestamp {
+			timestamp = session.Dump / 1000
+		}
+	}
+	return timestamp
+}
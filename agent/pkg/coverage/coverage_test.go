@@ -0,0 +1,292 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseTestReport(t *testing.T) *Report {
+	report, err := ParseFile("testdata/report.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestSummarize(t *testing.T) {
+	report := parseTestReport(t)
+
+	summary := Summarize("project", report, nil)
+	if summary.Counters.Instruction != (Counter{Missed: 6, Covered: 9}) {
+		t.Errorf("instruction = %+v", summary.Counters.Instruction)
+	}
+	if summary.Counters.Line != (Counter{Missed: 2, Covered: 3}) {
+		t.Errorf("line = %+v", summary.Counters.Line)
+	}
+	if len(summary.Packages) != 2 || summary.Packages[0].Name != "com/example/order" {
+		t.Fatalf("packages = %+v", summary.Packages)
+	}
+	if summary.Packages[0].Counters.Branch != (Counter{Missed: 1, Covered: 1}) {
+		t.Errorf("order branch = %+v", summary.Packages[0].Counters.Branch)
+	}
+
+	filtered := Summarize("user", report, func(className string) bool {
+		return className == "com/example/user/UserService"
+	})
+	if len(filtered.Packages) != 1 || filtered.Packages[0].Name != "com/example/user" {
+		t.Fatalf("packages = %+v", filtered.Packages)
+	}
+	if filtered.Counters.Class != (Counter{Missed: 1, Covered: 0}) {
+		t.Errorf("class = %+v", filtered.Counters.Class)
+	}
+	if filtered.Counters.Instruction.Ratio() != 0 {
+		t.Errorf("ratio = %v", filtered.Counters.Instruction.Ratio())
+	}
+}
+
+const testDiff = `diff --git a/order/src/main/java/com/example/order/OrderService.java b/order/src/main/java/com/example/order/OrderService.java
+index 1111111..2222222 100644
+--- a/order/src/main/java/com/example/order/OrderService.java
++++ b/order/src/main/java/com/example/order/OrderService.java
+@@ -7,3 +7,5 @@ public class OrderService {
+ 
+-    public String create(int id) {
++    public String create(int id) {
++        if (id > 0) {
+         return "ok";
++        }
+diff --git a/README.md b/README.md
+deleted file mode 100644
+--- a/README.md
++++ /dev/null
+@@ -1 +0,0 @@
+-readme
+`
+
+func testSourceRoot(root string) func(group string) string {
+	return func(group string) string {
+		return root + group
+	}
+}
+
+func TestGroupReports(t *testing.T) {
+	order, user := parseTestReport(t), parseTestReport(t)
+	user.Sessions = append(user.Sessions, SessionInfo{ID: "pod-2", Start: 1633000000000})
+	project := GroupReports("project", map[string]*Report{"user": user, "order": order})
+
+	dir, err := ioutil.TempDir("", "coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := project.WriteFile(filepath.Join(dir, "report.xml")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ParseFile(filepath.Join(dir, "report.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Groups) != 2 || report.Groups[0].Name != "order" || report.Groups[1].Name != "user" {
+		t.Fatalf("groups = %+v", report.Groups)
+	}
+	if len(report.Sessions) != 2 || report.Sessions[0].ID != "pod-2" {
+		t.Errorf("sessions = %+v", report.Sessions)
+	}
+	if counter := counterOf(report.Counters, CounterInstruction); counter != (Counter{Type: CounterInstruction, Missed: 12, Covered: 18}) {
+		t.Errorf("instruction = %+v", counter)
+	}
+
+	summary := Summarize("project", report, nil)
+	if len(summary.Packages) != 2 || summary.Packages[0].Counters.Line != (Counter{Missed: 2, Covered: 6}) {
+		t.Errorf("packages = %+v", summary.Packages)
+	}
+
+	group, ok := report.Group("user")
+	if !ok || len(group.Packages) != 2 || !reflect.DeepEqual(Summarize("user", group, nil).Counters, Summarize("", order, nil).Counters) {
+		t.Errorf("user group = %+v", group)
+	}
+	if _, ok := report.Group("missing"); ok {
+		t.Errorf("missing group found")
+	}
+}
+
+func TestDiffCoverage(t *testing.T) {
+	changes, err := ParseUnifiedDiff(strings.NewReader(testDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{"order/src/main/java/com/example/order/OrderService.java": {8, 9, 11}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+
+	summary := DiffCoverage(parseTestReport(t), changes)
+	if summary.Lines != (Counter{Missed: 1, Covered: 2}) {
+		t.Errorf("lines = %+v", summary.Lines)
+	}
+	if summary.Branches != (Counter{Missed: 1, Covered: 1}) {
+		t.Errorf("branches = %+v", summary.Branches)
+	}
+	if len(summary.Files) != 1 || !reflect.DeepEqual(summary.Files[0].MissedLines, []int{11}) {
+		t.Errorf("files = %+v", summary.Files)
+	}
+}
+
+func TestWriteCobertura(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCobertura(&buf, parseTestReport(t), testSourceRoot("/src")); err != nil {
+		t.Fatal(err)
+	}
+
+	var cobertura coberturaCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &cobertura); err != nil {
+		t.Fatal(err)
+	}
+	if cobertura.LinesValid != 5 || cobertura.LinesCovered != 3 || cobertura.LineRate != "0.6000" {
+		t.Errorf("coverage = %+v", cobertura)
+	}
+	if len(cobertura.Packages) != 2 || cobertura.Packages[0].Name != "com.example.order" {
+		t.Fatalf("packages = %+v", cobertura.Packages)
+	}
+	class := cobertura.Packages[0].Classes[0]
+	if class.Name != "com.example.order.OrderService" || class.Filename != "com/example/order/OrderService.java" {
+		t.Errorf("class = %v %v", class.Name, class.Filename)
+	}
+	wantLine := coberturaLine{Number: 8, Hits: 1, Branch: true, ConditionCoverage: "50% (1/2)"}
+	if class.Lines[1] != wantLine {
+		t.Errorf("line = %+v, want %+v", class.Lines[1], wantLine)
+	}
+	if len(class.Methods) != 2 || class.Methods[1].Name != "create" {
+		t.Errorf("methods = %+v", class.Methods)
+	}
+}
+
+func TestWriteLcov(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteLcov(&buf, parseTestReport(t), "/src"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `TN:
+SF:/src/com/example/order/OrderService.java
+FN:5,OrderService.<init>()V
+FNDA:1,OrderService.<init>()V
+FN:8,OrderService.create(I)Ljava/lang/String;
+FNDA:1,OrderService.create(I)Ljava/lang/String;
+FNF:2
+FNH:2
+BRDA:8,0,0,1
+BRDA:8,0,1,0
+BRF:2
+BRH:1
+DA:5,1
+DA:8,1
+DA:9,1
+DA:11,0
+LF:4
+LH:3
+end_of_record
+TN:
+SF:/src/com/example/user/UserService.java
+FN:3,UserService.find()V
+FNDA:0,UserService.find()V
+FNF:1
+FNH:0
+BRF:0
+BRH:0
+DA:3,0
+LF:1
+LH:0
+end_of_record
+`
+	if buf.String() != want {
+		t.Errorf("lcov = %v, want %v", buf.String(), want)
+	}
+}
+
+func TestWriteSonar(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSonar(&buf, parseTestReport(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var sonar sonarCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &sonar); err != nil {
+		t.Fatal(err)
+	}
+	if sonar.Version != 1 || len(sonar.Files) != 2 || sonar.Files[0].Path != "com/example/order/OrderService.java" {
+		t.Fatalf("sonar = %+v", sonar)
+	}
+	line := sonar.Files[0].Lines[1]
+	if line.LineNumber != 8 || !line.Covered || line.BranchesToCover != 2 || *line.CoveredBranches != 1 {
+		t.Errorf("line = %+v", line)
+	}
+	if sonar.Files[0].Lines[0].CoveredBranches != nil {
+		t.Errorf("line without branches has coveredBranches")
+	}
+}
+
+func TestGroupedFormats(t *testing.T) {
+	// the same classes in two services are reported per service, the user service covers the line
+	// and the branch the order service misses
+	user := parseTestReport(t)
+	orderService := user.Packages[0].SourceFiles[0].Lines
+	orderService[1].MB, orderService[1].CB = 0, 2
+	orderService[3].MI, orderService[3].CI = 0, 4
+	project := GroupReports("project", map[string]*Report{"user": user, "order": parseTestReport(t)})
+
+	changes := map[string][]int{"order/src/main/java/com/example/order/OrderService.java": {8, 9, 11}}
+	summary := DiffCoverage(project, changes)
+	if summary.Lines != (Counter{Missed: 0, Covered: 3}) || summary.Branches != (Counter{Missed: 0, Covered: 2}) {
+		t.Errorf("lines = %+v, branches = %+v", summary.Lines, summary.Branches)
+	}
+	wantServices := []DiffService{
+		{Name: "order", Lines: Counter{Missed: 1, Covered: 2}, Branches: Counter{Missed: 1, Covered: 1}},
+		{Name: "user", Lines: Counter{Missed: 0, Covered: 3}, Branches: Counter{Missed: 0, Covered: 2}},
+	}
+	if !reflect.DeepEqual(summary.Services, wantServices) {
+		t.Errorf("services = %+v, want %+v", summary.Services, wantServices)
+	}
+	if len(summary.Files) != 2 || summary.Files[0].Service != "order" || summary.Files[1].Service != "user" {
+		t.Errorf("files = %+v", summary.Files)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCobertura(&buf, project, testSourceRoot("/src/")); err != nil {
+		t.Fatal(err)
+	}
+	var cobertura coberturaCoverage
+	if err := xml.Unmarshal(buf.Bytes(), &cobertura); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cobertura.Sources, []string{"/src/order", "/src/user"}) {
+		t.Errorf("sources = %v", cobertura.Sources)
+	}
+	if len(cobertura.Packages) != 4 || cobertura.Packages[0].Name != "order:com.example.order" || cobertura.Packages[2].Name != "user:com.example.order" {
+		t.Fatalf("packages = %+v", cobertura.Packages)
+	}
+	if filename := cobertura.Packages[2].Classes[0].Filename; filename != "com/example/order/OrderService.java" {
+		t.Errorf("filename = %v", filename)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// ParseUnifiedDiff returns the added or changed lines of each file in the new revision.
+// Deleted files are skipped, paths have the a/ b/ prefixes of git removed.
+func ParseUnifiedDiff(r io.Reader) (map[string][]int, error) {
+	var changes = map[string][]int{}
+	var file string
+	var line, oldRemain, newRemain int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		inHunk := oldRemain > 0 || newRemain > 0
+		switch {
+		case inHunk && strings.HasPrefix(text, "+"):
+			if file != "" {
+				changes[file] = append(changes[file], line)
+			}
+			line++
+			newRemain--
+		case inHunk && strings.HasPrefix(text, "-"):
+			oldRemain--
+		case inHunk && (strings.HasPrefix(text, " ") || text == ""):
+			line++
+			oldRemain--
+			newRemain--
+		case strings.HasPrefix(text, `\`):
+		case strings.HasPrefix(text, "+++ "):
+			file = diffPath(strings.TrimPrefix(text, "+++ "))
+		case strings.HasPrefix(text, "@@ "):
+			match := hunkHeaderRegex.FindStringSubmatch(text)
+			if match == nil {
+				return nil, fmt.Errorf("malformed hunk header: %v", text)
+			}
+			oldRemain, newRemain = hunkLength(match[1]), hunkLength(match[3])
+			line, _ = strconv.Atoi(match[2])
+		case inHunk:
+			return nil, fmt.Errorf("malformed hunk line: %v", text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func hunkLength(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func diffPath(path string) string {
+	if i := strings.Index(path, "\t"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
+
+// DiffSummary is the coverage of the changed executable lines, with the coverage of each service
+// when the report has a group per service
+type DiffSummary struct {
+	Lines    Counter       `json:"lines"`
+	Branches Counter       `json:"branches"`
+	Services []DiffService `json:"services,omitempty"`
+	Files    []DiffFile    `json:"files,omitempty"`
+}
+
+type DiffService struct {
+	Name     string  `json:"name"`
+	Lines    Counter `json:"lines"`
+	Branches Counter `json:"branches"`
+}
+
+type DiffFile struct {
+	Path        string  `json:"path"`
+	Service     string  `json:"service,omitempty"`
+	Lines       Counter `json:"lines"`
+	Branches    Counter `json:"branches"`
+	MissedLines []int   `json:"missedLines,omitempty"`
+}
+
+// DiffCoverage computes the coverage of the changed lines, a changed file is matched
+// to the source file of the report whose package path is a suffix of the changed path.
+// The files are matched in each group on its own, so a class of the same name in two services
+// is listed once per service against the lines of each. The totals count each changed line once,
+// covered when a service covers it.
+func DiffCoverage(report *Report, changes map[string][]int) *DiffSummary {
+	var paths []string
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var summary DiffSummary
+	var mergedLines = map[string]map[int]LineNode{}
+	_ = report.eachGroup(func(name string, groupReport *Report) error {
+		var sourceFiles = map[string]*SourceFile{}
+		for _, pkg := range groupReport.AllPackages() {
+			for i := range pkg.SourceFiles {
+				sourceFiles[sourceFilePath(pkg.Name, pkg.SourceFiles[i].Name)] = &pkg.SourceFiles[i]
+			}
+		}
+
+		var service = DiffService{Name: name}
+		for _, path := range paths {
+			sourceFile := matchSourceFile(sourceFiles, path)
+			if sourceFile == nil {
+				continue
+			}
+			lines := changedLines(sourceFile, changes[path])
+			if len(lines) == 0 {
+				continue
+			}
+
+			diffFile := diffFileOf(path, lines)
+			diffFile.Service = name
+			service.Lines = service.Lines.add(diffFile.Lines)
+			service.Branches = service.Branches.add(diffFile.Branches)
+			summary.Files = append(summary.Files, diffFile)
+
+			if mergedLines[path] == nil {
+				mergedLines[path] = map[int]LineNode{}
+			}
+			for _, line := range lines {
+				mergedLines[path][line.Nr] = mergeLine(mergedLines[path][line.Nr], line)
+			}
+		}
+		if name != "" {
+			summary.Services = append(summary.Services, service)
+		}
+		return nil
+	})
+
+	for _, path := range paths {
+		lines, ok := mergedLines[path]
+		if !ok {
+			continue
+		}
+		var merged []LineNode
+		for _, nr := range changes[path] {
+			if line, ok := lines[nr]; ok {
+				merged = append(merged, line)
+			}
+		}
+		diffFile := diffFileOf(path, merged)
+		summary.Lines = summary.Lines.add(diffFile.Lines)
+		summary.Branches = summary.Branches.add(diffFile.Branches)
+	}
+	return &summary
+}
+
+// changedLines returns the executable lines of the source file among the changed line numbers
+func changedLines(sourceFile *SourceFile, nrs []int) []LineNode {
+	var lines = map[int]LineNode{}
+	for _, line := range sourceFile.Lines {
+		lines[line.Nr] = line
+	}
+	var changed []LineNode
+	for _, nr := range nrs {
+		if line, ok := lines[nr]; ok {
+			changed = append(changed, line)
+		}
+	}
+	return changed
+}
+
+func diffFileOf(path string, lines []LineNode) DiffFile {
+	var diffFile = DiffFile{Path: path}
+	for _, line := range lines {
+		if line.CI > 0 {
+			diffFile.Lines.Covered++
+		} else {
+			diffFile.Lines.Missed++
+			diffFile.MissedLines = append(diffFile.MissedLines, line.Nr)
+		}
+		diffFile.Branches.Missed += line.MB
+		diffFile.Branches.Covered += line.CB
+	}
+	return diffFile
+}
+
+// mergeLine merges the hits of a line in two services, the line is covered when one of them covers it
+func mergeLine(a LineNode, b LineNode) LineNode {
+	var merged = LineNode{Nr: b.Nr, CI: max(a.CI, b.CI), CB: max(a.CB, b.CB)}
+	if merged.CI == 0 {
+		merged.MI = max(a.MI, b.MI)
+	}
+	merged.MB = max(a.MB+a.CB, b.MB+b.CB) - merged.CB
+	return merged
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func sourceFilePath(pkgName string, fileName string) string {
+	if pkgName == "" {
+		return fileName
+	}
+	return pkgName + "/" + fileName
+}
+
+func matchSourceFile(sourceFiles map[string]*SourceFile, path string) *SourceFile {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		if sourceFile, ok := sourceFiles[strings.Join(parts[i:], "/")]; ok {
+			return sourceFile
+		}
+	}
+	return nil
+}
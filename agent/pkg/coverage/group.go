@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"sort"
+)
+
+const reportDocType = `<!DOCTYPE report PUBLIC "-//JACOCO//DTD Report 1.1//EN" "report.dtd">`
+
+// the order of the counters in a jacoco report
+var counterTypes = []string{CounterInstruction, CounterBranch, CounterLine, CounterComplexity, CounterMethod, CounterClass}
+
+// GroupReports returns a report with a group of each report by name, as the jacoco report of a project
+// with a group per service. Its counters are the sums of the groups, and its sessions the ones of all reports.
+func GroupReports(name string, reports map[string]*Report) *Report {
+	var names []string
+	for groupName := range reports {
+		names = append(names, groupName)
+	}
+	sort.Strings(names)
+
+	var report = Report{Name: name}
+	var sessions = map[string]bool{}
+	var counters = map[string]Counter{}
+	for _, groupName := range names {
+		r := reports[groupName]
+		for _, session := range r.Sessions {
+			if !sessions[session.ID] {
+				sessions[session.ID] = true
+				report.Sessions = append(report.Sessions, session)
+			}
+		}
+		for _, counter := range r.Counters {
+			counters[counter.Type] = counters[counter.Type].add(counter)
+		}
+		report.Groups = append(report.Groups, Group{
+			Name:     groupName,
+			Groups:   r.Groups,
+			Packages: r.Packages,
+			Counters: r.Counters,
+		})
+	}
+	sort.SliceStable(report.Sessions, func(i, j int) bool {
+		return report.Sessions[i].Start < report.Sessions[j].Start
+	})
+	for _, counterType := range counterTypes {
+		if counter, ok := counters[counterType]; ok {
+			counter.Type = counterType
+			report.Counters = append(report.Counters, counter)
+		}
+	}
+	return &report
+}
+
+// Group returns the report of a top level group, as the report of a service in a project report
+func (r *Report) Group(name string) (*Report, bool) {
+	for _, group := range r.Groups {
+		if group.Name == name {
+			return &Report{
+				Name:     group.Name,
+				Sessions: r.Sessions,
+				Groups:   group.Groups,
+				Packages: group.Packages,
+				Counters: group.Counters,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// eachGroup calls fn with the report of each top level group, as the report of each service in a project report.
+// The packages outside the groups, as all packages of a report without groups, are given with an empty name.
+func (r *Report) eachGroup(fn func(name string, report *Report) error) error {
+	if len(r.Packages) > 0 || len(r.Groups) == 0 {
+		if err := fn("", &Report{Name: r.Name, Sessions: r.Sessions, Packages: r.Packages, Counters: r.Counters}); err != nil {
+			return err
+		}
+	}
+	for _, group := range r.Groups {
+		groupReport, _ := r.Group(group.Name)
+		if err := fn(group.Name, groupReport); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes the report in jacoco xml
+func (r *Report) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header+reportDocType); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(r)
+}
+
+// WriteFile writes the report in jacoco xml to path
+func (r *Report) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// WriteLcov converts the jacoco report into a lcov tracefile, with one record per source file under sourceRoot.
+// Jacoco has no hit counts, so covered lines, branches and methods are reported with 1 hit.
+func WriteLcov(w io.Writer, report *Report, sourceRoot string) error {
+	writer := bufio.NewWriter(w)
+	for _, pkg := range report.AllPackages() {
+		var classesByFile = map[string][]ClassNode{}
+		for _, class := range pkg.Classes {
+			classesByFile[class.SourceFileName] = append(classesByFile[class.SourceFileName], class)
+		}
+		for _, sourceFile := range pkg.SourceFiles {
+			writeLcovRecord(writer, path.Join(sourceRoot, sourceFilePath(pkg.Name, sourceFile.Name)), sourceFile, classesByFile[sourceFile.Name])
+		}
+	}
+	return writer.Flush()
+}
+
+func writeLcovRecord(w *bufio.Writer, sourcePath string, sourceFile SourceFile, classes []ClassNode) {
+	fmt.Fprintf(w, "TN:\nSF:%v\n", sourcePath)
+
+	var functions, functionsHit int
+	for _, class := range classes {
+		simpleName := class.Name[strings.LastIndex(class.Name, "/")+1:]
+		for _, method := range class.Methods {
+			name := simpleName + "." + method.Name + method.Desc
+			var hits int
+			if counterOf(method.Counters, CounterMethod).Covered > 0 {
+				hits = 1
+				functionsHit++
+			}
+			functions++
+			fmt.Fprintf(w, "FN:%d,%v\nFNDA:%d,%v\n", method.Line, name, hits, name)
+		}
+	}
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", functions, functionsHit)
+
+	var branches, branchesHit int
+	for _, line := range sourceFile.Lines {
+		for i := 0; i < line.MB+line.CB; i++ {
+			var taken = "0"
+			if line.CI == 0 {
+				taken = "-"
+			} else if i < line.CB {
+				taken = "1"
+				branchesHit++
+			}
+			branches++
+			fmt.Fprintf(w, "BRDA:%d,0,%d,%v\n", line.Nr, i, taken)
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branches, branchesHit)
+
+	var linesHit int
+	for _, line := range sourceFile.Lines {
+		var hits int
+		if line.CI > 0 {
+			hits = 1
+			linesHit++
+		}
+		fmt.Fprintf(w, "DA:%d,%d\n", line.Nr, hits)
+	}
+	fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(sourceFile.Lines), linesHit)
+}
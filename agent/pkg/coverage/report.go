@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coverage parses JaCoCo xml reports and converts them into other formats.
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// counter types of jacoco
+const (
+	CounterInstruction = "INSTRUCTION"
+	CounterBranch      = "BRANCH"
+	CounterLine        = "LINE"
+	CounterComplexity  = "COMPLEXITY"
+	CounterMethod      = "METHOD"
+	CounterClass       = "CLASS"
+)
+
+// Report is the root element of a jacoco xml report
+type Report struct {
+	XMLName  xml.Name      `xml:"report"`
+	Name     string        `xml:"name,attr"`
+	Sessions []SessionInfo `xml:"sessioninfo"`
+	Groups   []Group       `xml:"group"`
+	Packages []Package     `xml:"package"`
+	Counters []Counter     `xml:"counter"`
+}
+
+type SessionInfo struct {
+	ID    string `xml:"id,attr"`
+	Start int64  `xml:"start,attr"`
+	Dump  int64  `xml:"dump,attr"`
+}
+
+type Group struct {
+	Name     string    `xml:"name,attr"`
+	Groups   []Group   `xml:"group"`
+	Packages []Package `xml:"package"`
+	Counters []Counter `xml:"counter"`
+}
+
+type Package struct {
+	Name        string       `xml:"name,attr"`
+	Classes     []ClassNode  `xml:"class"`
+	SourceFiles []SourceFile `xml:"sourcefile"`
+	Counters    []Counter    `xml:"counter"`
+}
+
+type ClassNode struct {
+	Name           string    `xml:"name,attr"`
+	SourceFileName string    `xml:"sourcefilename,attr,omitempty"`
+	Methods        []Method  `xml:"method"`
+	Counters       []Counter `xml:"counter"`
+}
+
+type Method struct {
+	Name     string    `xml:"name,attr"`
+	Desc     string    `xml:"desc,attr"`
+	Line     int       `xml:"line,attr,omitempty"`
+	Counters []Counter `xml:"counter"`
+}
+
+type SourceFile struct {
+	Name     string     `xml:"name,attr"`
+	Lines    []LineNode `xml:"line"`
+	Counters []Counter  `xml:"counter"`
+}
+
+// LineNode is the coverage of one source line: missed/covered instructions and branches
+type LineNode struct {
+	Nr int `xml:"nr,attr"`
+	MI int `xml:"mi,attr"`
+	CI int `xml:"ci,attr"`
+	MB int `xml:"mb,attr"`
+	CB int `xml:"cb,attr"`
+}
+
+type Counter struct {
+	Type    string `xml:"type,attr" json:"-"`
+	Missed  int    `xml:"missed,attr" json:"missed"`
+	Covered int    `xml:"covered,attr" json:"covered"`
+}
+
+func (c Counter) Total() int {
+	return c.Missed + c.Covered
+}
+
+// Ratio is the covered ratio, 0 when there is nothing to cover
+func (c Counter) Ratio() float64 {
+	if c.Total() == 0 {
+		return 0
+	}
+	return float64(c.Covered) / float64(c.Total())
+}
+
+func (c Counter) add(other Counter) Counter {
+	return Counter{Type: c.Type, Missed: c.Missed + other.Missed, Covered: c.Covered + other.Covered}
+}
+
+// ParseFile parses a jacoco xml report
+func ParseFile(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var report Report
+	decoder := xml.NewDecoder(f)
+	decoder.Strict = false
+	if err := decoder.Decode(&report); err != nil {
+		return nil, fmt.Errorf("parse jacoco xml report %v error %v", path, err)
+	}
+	return &report, nil
+}
+
+// AllPackages returns the packages of the report including those in groups
+func (r *Report) AllPackages() []Package {
+	packages := append([]Package{}, r.Packages...)
+	for _, group := range r.Groups {
+		packages = append(packages, group.allPackages()...)
+	}
+	return packages
+}
+
+func (g *Group) allPackages() []Package {
+	packages := append([]Package{}, g.Packages...)
+	for _, group := range g.Groups {
+		packages = append(packages, group.allPackages()...)
+	}
+	return packages
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+type sonarCoverage struct {
+	XMLName xml.Name    `xml:"coverage"`
+	Version int         `xml:"version,attr"`
+	Files   []sonarFile `xml:"file"`
+}
+
+type sonarFile struct {
+	Path  string      `xml:"path,attr"`
+	Lines []sonarLine `xml:"lineToCover"`
+}
+
+type sonarLine struct {
+	LineNumber      int  `xml:"lineNumber,attr"`
+	Covered         bool `xml:"covered,attr"`
+	BranchesToCover int  `xml:"branchesToCover,attr,omitempty"`
+	CoveredBranches *int `xml:"coveredBranches,attr"`
+}
+
+// WriteSonar converts the jacoco report into the sonarqube generic test coverage format,
+// file paths are relative to the source roots. A class may be in two services, so a report
+// with a group of each service is written per group.
+func WriteSonar(w io.Writer, report *Report) error {
+	var sonar = sonarCoverage{Version: 1}
+	for _, pkg := range report.AllPackages() {
+		for _, sourceFile := range pkg.SourceFiles {
+			var file = sonarFile{Path: sourceFilePath(pkg.Name, sourceFile.Name)}
+			for _, line := range sourceFile.Lines {
+				var sonarLine = sonarLine{LineNumber: line.Nr, Covered: line.CI > 0}
+				if branches := line.MB + line.CB; branches > 0 {
+					coveredBranches := line.CB
+					sonarLine.BranchesToCover = branches
+					sonarLine.CoveredBranches = &coveredBranches
+				}
+				file.Lines = append(file.Lines, sonarLine)
+			}
+			sonar.Files = append(sonar.Files, file)
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(sonar); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
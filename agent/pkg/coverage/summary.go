@@ -0,0 +1,106 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import "sort"
+
+// Counters are the jacoco counters reported in a summary
+type Counters struct {
+	Instruction Counter `json:"instruction"`
+	Branch      Counter `json:"branch"`
+	Line        Counter `json:"line"`
+	Method      Counter `json:"method"`
+	Class       Counter `json:"class"`
+}
+
+func (c *Counters) add(counters []Counter) {
+	for _, counter := range counters {
+		switch counter.Type {
+		case CounterInstruction:
+			c.Instruction = c.Instruction.add(counter)
+		case CounterBranch:
+			c.Branch = c.Branch.add(counter)
+		case CounterLine:
+			c.Line = c.Line.add(counter)
+		case CounterMethod:
+			c.Method = c.Method.add(counter)
+		case CounterClass:
+			c.Class = c.Class.add(counter)
+		}
+	}
+}
+
+// Summary is the coverage of a project or service, with the coverage of each package
+type Summary struct {
+	Name     string           `json:"name,omitempty"`
+	Counters Counters         `json:"counters"`
+	Packages []PackageSummary `json:"packages,omitempty"`
+	Services []*Summary       `json:"services,omitempty"`
+}
+
+type PackageSummary struct {
+	Name     string   `json:"name"`
+	Counters Counters `json:"counters"`
+}
+
+// Summarize aggregates the counters of the report, the packages of the same name in many groups are summed.
+// If filter is not nil, only classes accepted by it are counted and empty packages are dropped.
+func Summarize(name string, report *Report, filter func(className string) bool) *Summary {
+	var summary = Summary{Name: name}
+	if filter == nil {
+		summary.Counters.add(report.Counters)
+	}
+
+	var pkgIndex = map[string]int{}
+	for _, pkg := range report.AllPackages() {
+		var pkgSummary = PackageSummary{Name: pkg.Name}
+		if filter == nil {
+			pkgSummary.Counters.add(pkg.Counters)
+		} else {
+			var matched bool
+			for _, class := range pkg.Classes {
+				if filter(class.Name) {
+					matched = true
+					pkgSummary.Counters.add(class.Counters)
+				}
+			}
+			if !matched {
+				continue
+			}
+			summary.Counters.add(pkgSummary.Counters.list())
+		}
+		if i, ok := pkgIndex[pkg.Name]; ok {
+			summary.Packages[i].Counters.add(pkgSummary.Counters.list())
+			continue
+		}
+		pkgIndex[pkg.Name] = len(summary.Packages)
+		summary.Packages = append(summary.Packages, pkgSummary)
+	}
+
+	sort.Slice(summary.Packages, func(i, j int) bool {
+		return summary.Packages[i].Name < summary.Packages[j].Name
+	})
+	return &summary
+}
+
+func (c Counters) list() []Counter {
+	return []Counter{
+		{Type: CounterInstruction, Missed: c.Instruction.Missed, Covered: c.Instruction.Covered},
+		{Type: CounterBranch, Missed: c.Branch.Missed, Covered: c.Branch.Covered},
+		{Type: CounterLine, Missed: c.Line.Missed, Covered: c.Line.Covered},
+		{Type: CounterMethod, Missed: c.Method.Missed, Covered: c.Method.Covered},
+		{Type: CounterClass, Missed: c.Class.Missed, Covered: c.Class.Covered},
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extractcache keeps the dirs built from artifacts on disk, keyed by the digest of the artifact
+// content, so they are built once and shared by all plans and services. The least recently used
+// entries are evicted when the cache is over its size budget.
+package extractcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	entryDataDir  = "data"
+	entryMetaFile = "entry.json"
+	tempPrefix    = ".tmp-"
+)
+
+type entry struct {
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	// number of users of the entry, an entry in use is not evicted
+	refs int
+}
+
+// Cache is a dir of entries built by key, at most budget bytes are kept if no entry is in use
+type Cache struct {
+	dir    string
+	budget int64
+
+	lock     sync.Mutex
+	entries  map[string]*entry
+	building map[string]*sync.WaitGroup
+}
+
+// New opens the cache in dir, the entries left by an earlier run are kept
+func New(dir string, budget int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, budget: budget, entries: map[string]*entry{}, building: map[string]*sync.WaitGroup{}}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		p := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(filepath.Join(p, entryMetaFile))
+		if strings.HasPrefix(f.Name(), tempPrefix) || err != nil {
+			// an unfinished build
+			os.RemoveAll(p)
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			os.RemoveAll(p)
+			continue
+		}
+		c.entries[f.Name()] = &e
+	}
+
+	c.lock.Lock()
+	c.evict()
+	c.lock.Unlock()
+	return c, nil
+}
+
+// Get returns the dir of the entry of key, built by build into an empty dir if it is not cached.
+// The entry is kept until release is called. hit reports if the entry was cached.
+func (c *Cache) Get(key string, build func(dir string) error) (dir string, release func(), hit bool, err error) {
+	for {
+		c.lock.Lock()
+		if e, ok := c.entries[key]; ok {
+			e.refs++
+			e.LastUsed = time.Now()
+			c.writeMeta(key, e)
+			c.lock.Unlock()
+			return c.dataDir(key), c.releaseFunc(key), true, nil
+		}
+		wait, ok := c.building[key]
+		if !ok {
+			break
+		}
+		c.lock.Unlock()
+		// built by another caller
+		wait.Wait()
+	}
+	var wait sync.WaitGroup
+	wait.Add(1)
+	c.building[key] = &wait
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.building, key)
+		c.lock.Unlock()
+		wait.Done()
+	}()
+
+	size, err := c.build(key, build)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	c.lock.Lock()
+	e := &entry{Size: size, LastUsed: time.Now(), refs: 1}
+	c.entries[key] = e
+	c.writeMeta(key, e)
+	c.evict()
+	c.lock.Unlock()
+	return c.dataDir(key), c.releaseFunc(key), false, nil
+}
+
+// Lookup returns the dir of the entry of key if it is cached, it is kept until release is called
+func (c *Cache) Lookup(key string) (dir string, release func(), ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", nil, false
+	}
+	e.refs++
+	e.LastUsed = time.Now()
+	c.writeMeta(key, e)
+	return c.dataDir(key), c.releaseFunc(key), true
+}
+
+// build builds the entry in a temp dir, and moves it into the cache when it is done
+func (c *Cache) build(key string, build func(dir string) error) (int64, error) {
+	temp, err := ioutil.TempDir(c.dir, tempPrefix)
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(temp)
+
+	data := filepath.Join(temp, entryDataDir)
+	if err := os.MkdirAll(data, 0755); err != nil {
+		return 0, err
+	}
+	if err := build(data); err != nil {
+		return 0, err
+	}
+	size, err := dirSize(data)
+	if err != nil {
+		return 0, err
+	}
+
+	p := filepath.Join(c.dir, key)
+	os.RemoveAll(p)
+	if err := os.Rename(temp, p); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (c *Cache) releaseFunc(key string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if e, ok := c.entries[key]; ok && e.refs > 0 {
+				e.refs--
+			}
+			c.evict()
+		})
+	}
+}
+
+// evict removes the least recently used entries not in use until the cache fits the budget
+func (c *Cache) evict() {
+	var total int64
+	var keys []string
+	for key, e := range c.entries {
+		total += e.Size
+		keys = append(keys, key)
+	}
+	if c.budget <= 0 || total <= c.budget {
+		return
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].LastUsed.Before(c.entries[keys[j]].LastUsed)
+	})
+	for _, key := range keys {
+		if total <= c.budget {
+			return
+		}
+		e := c.entries[key]
+		if e.refs > 0 {
+			continue
+		}
+		// the meta file is removed first, so a partly removed entry is dropped on the next start
+		os.Remove(filepath.Join(c.dir, key, entryMetaFile))
+		os.RemoveAll(filepath.Join(c.dir, key))
+		delete(c.entries, key)
+		total -= e.Size
+	}
+}
+
+func (c *Cache) writeMeta(key string, e *entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(filepath.Join(c.dir, key, entryMetaFile), data, 0644)
+}
+
+func (c *Cache) dataDir(key string) string {
+	return filepath.Join(c.dir, key, entryDataDir)
+}
+
+// Size returns the total size of the entries
+func (c *Cache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var total int64
+	for _, e := range c.entries {
+		total += e.Size
+	}
+	return total
+}
+
+// Key returns a cache key of the digests and settings an entry is built from
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s;", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Digest returns the digest of the content of a file, or of the paths and contents of the files of a dir
+func Digest(p string) (string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if !info.IsDir() {
+		if err := hashFile(h, p); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	err = filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(p, file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%v\x00", filepath.ToSlash(rel), info.IsDir())
+		if info.Mode().IsRegular() {
+			return hashFile(h, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// LinkTree merges the files of src into dst with hard links, or copies when they can not be linked.
+// Files of dst are replaced by the ones of src with the same path.
+func LinkTree(src string, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		os.Remove(target)
+		if err := os.Link(p, target); err == nil {
+			return nil
+		}
+		return copyFile(p, target, info.Mode())
+	})
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
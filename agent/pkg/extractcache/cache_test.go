@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extractcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeEntry(size int) func(dir string) error {
+	return func(dir string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "classes"), []byte(strings.Repeat("x", size)), 0644)
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var builds int
+	build := func(dir string) error {
+		builds++
+		return writeEntry(10)(dir)
+	}
+	entry, release, hit, err := c.Get("a", build)
+	if err != nil || hit {
+		t.Fatalf("get a = %v %v", hit, err)
+	}
+	release()
+	if _, err := os.Stat(filepath.Join(entry, "classes")); err != nil {
+		t.Errorf("entry not built: %v", err)
+	}
+	_, release, hit, err = c.Get("a", build)
+	if err != nil || !hit || builds != 1 {
+		t.Fatalf("get a again = %v %v, builds %v", hit, err, builds)
+	}
+	release()
+	if dir, release, ok := c.Lookup("a"); !ok || dir != entry {
+		t.Errorf("lookup a = %v %v", dir, ok)
+	} else {
+		release()
+	}
+	if _, _, ok := c.Lookup("missing"); ok {
+		t.Errorf("missing entry found")
+	}
+
+	if _, _, _, err := c.Get("failed", func(string) error { return fmt.Errorf("failed") }); err == nil {
+		t.Errorf("build error not returned")
+	}
+	if c.Size() != 10 {
+		t.Errorf("size = %v", c.Size())
+	}
+
+	// b is in use while c is added, so a, the least recently used, is evicted
+	time.Sleep(10 * time.Millisecond)
+	_, releaseB, _, _ := c.Get("b", writeEntry(10))
+	_, releaseC, _, _ := c.Get("c", writeEntry(10))
+	if _, ok := c.entries["a"]; ok {
+		t.Errorf("a not evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("a not removed: %v", err)
+	}
+
+	// entries in use are kept over the budget
+	_, releaseD, _, _ := c.Get("d", writeEntry(10))
+	if c.Size() != 30 {
+		t.Errorf("size with entries in use = %v", c.Size())
+	}
+	releaseB()
+	releaseC()
+	releaseD()
+	if _, ok := c.entries["b"]; ok || c.Size() != 20 {
+		t.Errorf("b not evicted after release, size %v", c.Size())
+	}
+
+	// the entries are kept across restarts
+	reopened, err := New(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, release, hit, err = reopened.Get("d", build)
+	if err != nil || !hit {
+		t.Errorf("get d after reopen = %v %v", hit, err)
+	}
+	release()
+}
+
+func TestDigestAndLinkTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "com/example"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "com/example/A.class"), []byte("a"), 0644)
+
+	before, err := Digest(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(src, "com/example/A.class"), []byte("b"), 0644)
+	after, _ := Digest(src)
+	if before == after {
+		t.Errorf("digest not changed with the content")
+	}
+	if Key("a", "bc") == Key("ab", "c") {
+		t.Errorf("key parts not separated")
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := LinkTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dst, "com/example/A.class"))
+	if err != nil || string(data) != "b" {
+		t.Errorf("linked file = %q %v", data, err)
+	}
+}
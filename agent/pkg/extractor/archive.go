@@ -0,0 +1,184 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archive is the files of a jar, or of a dir as an exploded webapp, by slash separated name
+type archive struct {
+	files map[string]*archiveFile
+	names []string
+	close func() error
+}
+
+type archiveFile struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// openArchive opens a jar, or walks a dir
+func openArchive(p string) (*archive, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		reader, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, fmt.Errorf("open jar %v error %v", p, err)
+		}
+		a := newZipArchive(&reader.Reader)
+		a.close = reader.Close
+		return a, nil
+	}
+
+	a := &archive{files: map[string]*archiveFile{}, close: func() error { return nil }}
+	err = filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(p, file)
+		if err != nil {
+			return err
+		}
+		a.add(&archiveFile{name: filepath.ToSlash(rel), open: func() (io.ReadCloser, error) {
+			return os.Open(file)
+		}})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.sort()
+	return a, nil
+}
+
+// openNestedArchive opens a jar inside an archive, as a lib of a fat jar
+func openNestedArchive(f *archiveFile) (*archive, error) {
+	data, err := readFile(f)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("open jar %v error %v", f.name, err)
+	}
+	a := newZipArchive(reader)
+	a.close = func() error { return nil }
+	return a, nil
+}
+
+func newZipArchive(reader *zip.Reader) *archive {
+	a := &archive{files: map[string]*archiveFile{}}
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		f := f
+		a.add(&archiveFile{name: f.Name, open: f.Open})
+	}
+	a.sort()
+	return a
+}
+
+func (a *archive) add(f *archiveFile) {
+	f.name = strings.TrimPrefix(f.name, "/")
+	if _, ok := a.files[f.name]; !ok {
+		a.names = append(a.names, f.name)
+	}
+	a.files[f.name] = f
+}
+
+func (a *archive) sort() {
+	sort.Strings(a.names)
+}
+
+// hasDir reports if the archive has files under dir
+func (a *archive) hasDir(dir string) bool {
+	for _, name := range a.names {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the files under dir matching the name pattern of path.Match, in any sub dir
+func (a *archive) list(dir string, pattern string) []*archiveFile {
+	var files []*archiveFile
+	for _, name := range a.names {
+		if dir != "" && !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			files = append(files, a.files[name])
+		}
+	}
+	return files
+}
+
+func readFile(f *archiveFile) ([]byte, error) {
+	r, err := f.open()
+	if err != nil {
+		return nil, fmt.Errorf("open %v error %v", f.name, err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read %v error %v", f.name, err)
+	}
+	return data, nil
+}
+
+// writeFile writes a file of an archive to dir/rel, rel must stay in dir
+func writeFile(f *archiveFile, dir string, rel string) error {
+	rel = path.Clean("/" + rel)[1:]
+	if rel == "" {
+		return fmt.Errorf("invalid file name %v", f.name)
+	}
+	dest := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	r, err := f.open()
+	if err != nil {
+		return fmt.Errorf("open %v error %v", f.name, err)
+	}
+	defer r.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("extract %v error %v", f.name, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,348 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extractor extracts the classes of an application artifact and of its own libraries,
+// and the sources of the libraries, for the coverage report.
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultGroupBlacklist is the group id prefixes of third party libraries, their classes are not reported
+var DefaultGroupBlacklist = []string{
+	"net.bytebuddy",
+	"org.apache",
+	"org.glassfish",
+	"com.fasterxml",
+	"io.netty",
+	"org.springframework",
+	"io.github",
+	"com.google",
+	"com.alibaba",
+	"javax",
+	"org.jboss",
+	"com.aliyun",
+	"commons-",
+	"com.sun",
+	"org.yaml",
+	"jakarta",
+	"net.sf",
+	"com.github",
+	"com.codehaus",
+	"org.jacoco",
+	"software.amazon",
+	"redis",
+	"org.slf4j",
+	"org.redis",
+	"org.hibernate",
+	"org.ehcache",
+	"com.amazon",
+	"cn.hutool",
+	"org.quartz",
+}
+
+// FrameworkGroupBlacklist is the group id prefixes of the frameworks a Quarkus app keeps in lib/main and a shaded jar
+// bundles at its root, they are added to the group blacklist for these layouts only
+var FrameworkGroupBlacklist = []string{
+	"io.quarkus",
+	"io.smallrye",
+	"io.vertx",
+	"io.micronaut",
+	"io.projectreactor",
+	"io.reactivex",
+	"org.reactivestreams",
+	"org.jetbrains",
+	"org.eclipse",
+}
+
+// reasons a library is taken or skipped
+const (
+	ReasonTaken          = "taken"
+	ReasonNoDescriptor   = "no maven descriptor"
+	ReasonIncompletePom  = "incomplete pom.properties"
+	ReasonBlacklistGroup = "blacklisted group"
+)
+
+// Library is a jar packaged in the application
+type Library struct {
+	File       string `json:"file"`
+	GroupID    string `json:"groupId,omitempty"`
+	ArtifactID string `json:"artifactId,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Taken      bool   `json:"taken"`
+	Reason     string `json:"reason"`
+	Sources    bool   `json:"sources"`
+}
+
+// Result is what was extracted from an artifact
+type Result struct {
+	Artifact  string    `json:"artifact"`
+	Layout    string    `json:"layout"`
+	Libraries []Library `json:"libraries,omitempty"`
+	// classes kept by package dir, as com/example/order
+	Packages        map[string]int `json:"packages"`
+	Classes         int            `json:"classes"`
+	ExcludedClasses int            `json:"excludedClasses"`
+	Sources         int            `json:"sources"`
+	// the sources of the libraries are optional, failing to get them does not fail the extraction
+	SourceError string `json:"sourceError,omitempty"`
+}
+
+// SourceResolver returns the sources jars of the libraries taken by library file name,
+// the sources found are used even if an error is returned
+type SourceResolver interface {
+	Resolve(libs []Library) (map[string]string, error)
+}
+
+// Options of an extractor
+type Options struct {
+	// colon separated globs of the package dirs to keep, all by default
+	Includes string
+	// colon separated globs of the package dirs to drop
+	Excludes string
+	// group id prefixes of the libraries not taken, DefaultGroupBlacklist if nil
+	GroupBlacklist []string
+	// resolves the sources of the libraries taken, their sources are skipped if nil
+	Sources SourceResolver
+}
+
+// Extractor extracts artifacts with the same options
+type Extractor struct {
+	opts   Options
+	filter *Filter
+}
+
+func New(opts Options) *Extractor {
+	if opts.GroupBlacklist == nil {
+		opts.GroupBlacklist = DefaultGroupBlacklist
+	}
+	return &Extractor{opts: opts, filter: NewFilter(opts.Includes, opts.Excludes)}
+}
+
+// Inputs returns the files and dirs besides an artifact its extraction reads, as the libraries of a thin jar,
+// the extraction of the artifact changes with them too
+func (e *Extractor) Inputs(artifact string) ([]string, error) {
+	a, err := openArchive(artifact)
+	if err != nil {
+		return nil, err
+	}
+	defer a.close()
+
+	l, err := e.detectLayout(artifact, a)
+	if err != nil {
+		return nil, err
+	}
+	defer l.close()
+	return l.inputs, nil
+}
+
+// Extract writes the classes of an artifact, a jar or a dir, and of its own libraries into classDir,
+// and the sources of the libraries into sourceDir. The classes of the application override the ones of the libraries.
+func (e *Extractor) Extract(artifact string, classDir string, sourceDir string) (*Result, error) {
+	a, err := openArchive(artifact)
+	if err != nil {
+		return nil, err
+	}
+	defer a.close()
+
+	l, err := e.detectLayout(artifact, a)
+	if err != nil {
+		return nil, err
+	}
+	defer l.close()
+
+	var result = &Result{Artifact: artifact, Layout: l.name, Packages: map[string]int{}}
+	var kept = map[string]bool{}
+	if err := e.extractLibraries(l, classDir, sourceDir, result, kept); err != nil {
+		return nil, err
+	}
+	for _, root := range l.classes {
+		for _, f := range root.archive.list(strings.TrimSuffix(root.prefix, "/"), "*.class") {
+			name := strings.TrimPrefix(f.name, root.prefix)
+			if strings.HasPrefix(name, "META-INF/") || (root.skip != nil && root.skip(name)) {
+				continue
+			}
+			if err := e.extractClass(f, name, classDir, result, kept); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result.Classes = len(kept)
+	for name := range kept {
+		result.Packages[path.Dir(name)]++
+	}
+	return result, nil
+}
+
+func (e *Extractor) extractLibraries(l *layout, classDir string, sourceDir string, result *Result, kept map[string]bool) error {
+	var files = map[string]*archiveFile{}
+	for _, f := range l.libs {
+		lib, err := openNestedArchive(f)
+		if err != nil {
+			return err
+		}
+		library, err := e.library(lib, path.Base(f.name), e.groupBlacklist(l.name))
+		lib.close()
+		if err != nil {
+			return err
+		}
+		result.Libraries = append(result.Libraries, library)
+		files[library.File] = f
+	}
+
+	var sources map[string]string
+	if e.opts.Sources != nil && hasTaken(result.Libraries) {
+		var err error
+		sources, err = e.opts.Sources.Resolve(result.Libraries)
+		if err != nil {
+			result.SourceError = err.Error()
+		}
+	}
+
+	for i := range result.Libraries {
+		library := &result.Libraries[i]
+		if !library.Taken {
+			continue
+		}
+		if err := e.extractLibrary(files[library.File], classDir, result, kept); err != nil {
+			return err
+		}
+
+		sourceJar, ok := sources[library.File]
+		if !ok {
+			continue
+		}
+		library.Sources = true
+		if err := e.extractSources(sourceJar, sourceDir, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Extractor) extractLibrary(f *archiveFile, classDir string, result *Result, kept map[string]bool) error {
+	lib, err := openNestedArchive(f)
+	if err != nil {
+		return err
+	}
+	defer lib.close()
+	for _, class := range lib.list("", "*.class") {
+		if strings.HasPrefix(class.name, "META-INF/") {
+			continue
+		}
+		if err := e.extractClass(class, class.name, classDir, result, kept); err != nil {
+			return fmt.Errorf("extract library %v error %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+func hasTaken(libs []Library) bool {
+	for _, lib := range libs {
+		if lib.Taken {
+			return true
+		}
+	}
+	return false
+}
+
+// library reads the maven descriptor of a lib, and decides if its classes are taken
+func (e *Extractor) library(lib *archive, file string, blacklist []string) (Library, error) {
+	var library = Library{File: file}
+	descriptors := lib.list("META-INF/maven", "pom.properties")
+	if len(descriptors) <= 0 {
+		library.Reason = ReasonNoDescriptor
+		return library, nil
+	}
+	data, err := readFile(descriptors[0])
+	if err != nil {
+		return library, err
+	}
+	props := parseProperties(data)
+	library.GroupID, library.ArtifactID, library.Version = props["groupId"], props["artifactId"], props["version"]
+	if library.GroupID == "" || library.ArtifactID == "" || library.Version == "" {
+		library.Reason = ReasonIncompletePom
+		return library, nil
+	}
+	for _, prefix := range blacklist {
+		if strings.HasPrefix(library.GroupID, prefix) {
+			library.Reason = ReasonBlacklistGroup + " " + prefix
+			return library, nil
+		}
+	}
+	library.Taken = true
+	library.Reason = ReasonTaken
+	return library, nil
+}
+
+// groupBlacklist returns the group blacklist of a layout, with the frameworks for the Quarkus and shaded layouts
+func (e *Extractor) groupBlacklist(layoutName string) []string {
+	switch layoutName {
+	case LayoutQuarkus, LayoutShaded:
+		return append(append([]string{}, e.opts.GroupBlacklist...), FrameworkGroupBlacklist...)
+	}
+	return e.opts.GroupBlacklist
+}
+
+func (e *Extractor) extractClass(f *archiveFile, name string, classDir string, result *Result, kept map[string]bool) error {
+	if !e.filter.Match(path.Dir(name)) {
+		result.ExcludedClasses++
+		return nil
+	}
+	if err := writeFile(f, classDir, name); err != nil {
+		return err
+	}
+	kept[name] = true
+	return nil
+}
+
+func (e *Extractor) extractSources(sourceJar string, sourceDir string, result *Result) error {
+	a, err := openArchive(sourceJar)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	for _, f := range a.list("", "*.java") {
+		if strings.HasPrefix(f.name, "META-INF/") || !e.filter.Match(path.Dir(f.name)) {
+			continue
+		}
+		if err := writeFile(f, sourceDir, f.name); err != nil {
+			return err
+		}
+		result.Sources++
+	}
+	return nil
+}
+
+// parseProperties parses the key=value lines of a java properties file
+func parseProperties(data []byte) map[string]string {
+	var props = map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		if i := strings.IndexAny(line, "=:"); i > 0 {
+			props[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return props
+}
@@ -0,0 +1,357 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// jarOf returns a jar of the files by name
+func jarOf(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(files[name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func libOf(t *testing.T, groupID string, artifactID string, classes ...string) []byte {
+	var files = map[string][]byte{}
+	if groupID != "" {
+		files[fmt.Sprintf("META-INF/maven/%v/%v/pom.properties", groupID, artifactID)] =
+			[]byte(fmt.Sprintf("#Generated by Maven\ngroupId=%v\nartifactId=%v\nversion=1.0\n", groupID, artifactID))
+	}
+	for _, class := range classes {
+		files[class] = []byte("lib " + class)
+	}
+	return jarOf(t, files)
+}
+
+type testSources map[string]string
+
+func (s testSources) Resolve(libs []Library) (map[string]string, error) {
+	for _, lib := range libs {
+		if _, ok := s[lib.File]; ok && !lib.Taken {
+			return nil, fmt.Errorf("sources of %v not taken", lib.File)
+		}
+	}
+	return s, nil
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestExtractSpringBoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sourcesJar := filepath.Join(dir, "order-api-1.0-sources.jar")
+	ioutil.WriteFile(sourcesJar, jarOf(t, map[string][]byte{
+		"com/example/api/OrderApi.java":      []byte("class OrderApi {}"),
+		"com/example/internal/Internal.java": []byte("class Internal {}"),
+		"META-INF/MANIFEST.MF":               []byte(""),
+	}), 0644)
+
+	artifact := filepath.Join(dir, "app.jar")
+	ioutil.WriteFile(artifact, jarOf(t, map[string][]byte{
+		"META-INF/maven/com.example/app/pom.xml":        []byte("<project/>"),
+		"BOOT-INF/classes/com/example/App.class":        []byte("app"),
+		"BOOT-INF/classes/com/example/api/Shared.class": []byte("app shared"),
+		"BOOT-INF/classes/application.yml":              []byte("server: {}"),
+		"BOOT-INF/lib/order-api-1.0.jar": libOf(t, "com.example", "order-api",
+			"com/example/api/OrderApi.class", "com/example/api/Shared.class", "com/example/internal/Internal.class", "META-INF/versions/9/module-info.class"),
+		"BOOT-INF/lib/spring-core-5.3.jar": libOf(t, "org.springframework", "spring-core", "org/springframework/Core.class"),
+		"BOOT-INF/lib/plain.jar":           libOf(t, "", "", "com/plain/Plain.class"),
+	}), 0644)
+
+	classDir, sourceDir := filepath.Join(dir, "classes"), filepath.Join(dir, "sources")
+	e := New(Options{
+		Excludes: "com/example/internal*",
+		Sources:  testSources{"order-api-1.0.jar": sourcesJar},
+	})
+	result, err := e.Extract(artifact, classDir, sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Layout != LayoutSpringBoot {
+		t.Errorf("layout = %v", result.Layout)
+	}
+	if got, want := listFiles(t, classDir), []string{"com/example/App.class", "com/example/api/OrderApi.class", "com/example/api/Shared.class"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("classes = %v, want %v", got, want)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(classDir, "com/example/api/Shared.class")); string(data) != "app shared" {
+		t.Errorf("app class not override the lib one: %q", data)
+	}
+	if got, want := listFiles(t, sourceDir), []string{"com/example/api/OrderApi.java"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %v, want %v", got, want)
+	}
+
+	if !reflect.DeepEqual(result.Packages, map[string]int{"com/example": 1, "com/example/api": 2}) {
+		t.Errorf("packages = %v", result.Packages)
+	}
+	if result.Classes != 3 || result.ExcludedClasses != 1 || result.Sources != 1 {
+		t.Errorf("classes %v, excluded %v, sources %v", result.Classes, result.ExcludedClasses, result.Sources)
+	}
+
+	var reasons = map[string]string{}
+	for _, lib := range result.Libraries {
+		reasons[lib.File] = lib.Reason
+		if lib.File == "order-api-1.0.jar" && (!lib.Taken || !lib.Sources || lib.GroupID != "com.example") {
+			t.Errorf("order-api = %+v", lib)
+		}
+	}
+	if !reflect.DeepEqual(reasons, map[string]string{
+		"order-api-1.0.jar":   ReasonTaken,
+		"spring-core-5.3.jar": ReasonBlacklistGroup + " org.springframework",
+		"plain.jar":           ReasonNoDescriptor,
+	}) {
+		t.Errorf("reasons = %v", reasons)
+	}
+}
+
+func TestExtractDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	webapp := filepath.Join(dir, "webapp")
+	os.MkdirAll(filepath.Join(webapp, "WEB-INF/classes/com/example"), 0755)
+	os.MkdirAll(filepath.Join(webapp, "WEB-INF/lib"), 0755)
+	ioutil.WriteFile(filepath.Join(webapp, "WEB-INF/classes/com/example/Servlet.class"), []byte("servlet"), 0644)
+	ioutil.WriteFile(filepath.Join(webapp, "WEB-INF/lib/model-1.0.jar"), libOf(t, "com.example", "model", "com/example/model/Order.class"), 0644)
+	ioutil.WriteFile(filepath.Join(webapp, "index.jsp"), []byte(""), 0644)
+
+	result, err := New(Options{}).Extract(webapp, filepath.Join(dir, "webapp-classes"), filepath.Join(dir, "webapp-sources"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Layout != LayoutWar || result.SourceError != "" {
+		t.Errorf("result = %+v", result)
+	}
+	if got, want := listFiles(t, filepath.Join(dir, "webapp-classes")), []string{"com/example/Servlet.class", "com/example/model/Order.class"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("classes = %v, want %v", got, want)
+	}
+
+	classes := filepath.Join(dir, "target/classes")
+	os.MkdirAll(filepath.Join(classes, "com/example"), 0755)
+	ioutil.WriteFile(filepath.Join(classes, "com/example/Main.class"), []byte("main"), 0644)
+	ioutil.WriteFile(filepath.Join(classes, "logback.xml"), []byte(""), 0644)
+	result, err = New(Options{Includes: "com/*"}).Extract(classes, filepath.Join(dir, "dir-classes"), filepath.Join(dir, "dir-sources"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Layout != LayoutClassDir || result.Classes != 1 {
+		t.Errorf("result = %+v", result)
+	}
+
+	if _, err := New(Options{}).Extract(filepath.Join(dir, "missing.jar"), dir, dir); err == nil {
+		t.Errorf("missing artifact extracted")
+	}
+	broken := filepath.Join(dir, "broken.jar")
+	ioutil.WriteFile(broken, []byte("not a zip"), 0644)
+	if _, err := New(Options{}).Extract(broken, dir, dir); err == nil {
+		t.Errorf("broken artifact extracted")
+	}
+}
+
+func TestFrameworkGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the frameworks are only skipped in the Quarkus and shaded layouts, a war takes them as extract-jar.sh did
+	webapp := filepath.Join(dir, "webapp")
+	os.MkdirAll(filepath.Join(webapp, "WEB-INF/lib"), 0755)
+	ioutil.WriteFile(filepath.Join(webapp, "WEB-INF/lib/vertx-core-4.0.jar"), libOf(t, "io.vertx", "vertx-core", "io/vertx/core/Vertx.class"), 0644)
+	result, err := New(Options{}).Extract(webapp, filepath.Join(dir, "classes"), filepath.Join(dir, "sources"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Libraries) != 1 || !result.Libraries[0].Taken {
+		t.Errorf("libraries = %+v", result.Libraries)
+	}
+
+	e := New(Options{GroupBlacklist: []string{"com.example.thirdparty"}})
+	for _, c := range []struct {
+		layout  string
+		blocked bool
+	}{
+		{LayoutQuarkus, true},
+		{LayoutShaded, true},
+		{LayoutSpringBoot, false},
+		{LayoutWar, false},
+		{LayoutThin, false},
+	} {
+		blacklist := e.groupBlacklist(c.layout)
+		if blocked := len(blacklist) == 1+len(FrameworkGroupBlacklist); blocked != c.blocked || blacklist[0] != "com.example.thirdparty" {
+			t.Errorf("layout %v blacklist = %v", c.layout, blacklist)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	for _, c := range []struct {
+		includes string
+		excludes string
+		dir      string
+		match    bool
+	}{
+		{"", "", "com/example", true},
+		{"*", "*", "com/example", true},
+		{"com/example*", "", "com/example/order", true},
+		{"com/example*", "", "org/example", false},
+		{"org/*:com/ex?mple", "", "com/example", true},
+		{"com/*", "com/example/internal*", "com/example/internal/impl", false},
+		{"com.example", "", "com/example", false},
+	} {
+		if got := NewFilter(c.includes, c.excludes).Match(c.dir); got != c.match {
+			t.Errorf("filter %q %q match %v = %v", c.includes, c.excludes, c.dir, got)
+		}
+	}
+}
+
+func TestExtractLayouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := []byte("Manifest-Version: 1.0\r\nMain-Class: com.example.Main\r\nClass-Path: \r\n\r\n")
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	model := libOf(t, "com.example", "model", "com/example/model/Order.class")
+	spring := libOf(t, "org.springframework", "spring-core", "org/springframework/Core.class")
+
+	shaded := write("shaded/app.jar", jarOf(t, map[string][]byte{
+		"META-INF/MANIFEST.MF":                  manifest,
+		"com/example/Main.class":                []byte("main"),
+		"io/micronaut/runtime/Micronaut.class":  []byte("micronaut"),
+		"org/springframework/Core.class":        []byte("spring"),
+		"META-INF/versions/9/module-info.class": []byte("module"),
+	}))
+	thin := write("thin/app.jar", jarOf(t, map[string][]byte{
+		"META-INF/MANIFEST.MF":   manifest,
+		"com/example/Main.class": []byte("main"),
+	}))
+	write("thin/lib/model-1.0.jar", model)
+	write("thin/lib/spring-core-5.3.jar", spring)
+	library := write("thin/lib/model-copy.jar", model)
+
+	quarkus := filepath.Join(dir, "quarkus-app")
+	write("quarkus-app/quarkus-run.jar", jarOf(t, map[string][]byte{"META-INF/MANIFEST.MF": manifest}))
+	write("quarkus-app/app/order.jar", jarOf(t, map[string][]byte{"com/example/order/Resource.class": []byte("resource")}))
+	write("quarkus-app/lib/main/com.example.model-1.0.jar", model)
+	write("quarkus-app/lib/main/io.quarkus.quarkus-core-2.0.jar", libOf(t, "io.quarkus", "quarkus-core", "io/quarkus/Core.class"))
+
+	layered := filepath.Join(dir, "layered")
+	write("layered/application/BOOT-INF/classes/com/example/Main.class", []byte("main"))
+	write("layered/dependencies/BOOT-INF/lib/spring-core-5.3.jar", spring)
+	write("layered/snapshot-dependencies/BOOT-INF/lib/model-1.0-SNAPSHOT.jar", model)
+	write("layered/spring-boot-loader/org/springframework/boot/loader/JarLauncher.class", []byte("loader"))
+
+	for _, c := range []struct {
+		artifact string
+		layout   string
+		classes  []string
+		// the files besides the artifact read by its extraction
+		inputs []string
+	}{
+		{shaded, LayoutShaded, []string{"com/example/Main.class"}, nil},
+		{thin, LayoutThin, []string{"com/example/Main.class", "com/example/model/Order.class"},
+			[]string{"thin/lib/model-1.0.jar", "thin/lib/model-copy.jar", "thin/lib/spring-core-5.3.jar"}},
+		{library, LayoutLibrary, nil, nil},
+		{quarkus, LayoutQuarkus, []string{"com/example/model/Order.class", "com/example/order/Resource.class"}, nil},
+		{filepath.Join(quarkus, quarkusRunner), LayoutQuarkus, []string{"com/example/model/Order.class", "com/example/order/Resource.class"},
+			[]string{"quarkus-app"}},
+		{layered, LayoutSpringBootLayered, []string{"com/example/Main.class", "com/example/model/Order.class"}, nil},
+	} {
+		classDir := filepath.Join(dir, "out", c.layout, filepath.Base(c.artifact))
+		result, err := New(Options{}).Extract(c.artifact, classDir, filepath.Join(classDir, "sources"))
+		if err != nil {
+			t.Errorf("extract %v error %v", c.artifact, err)
+			continue
+		}
+		if result.Layout != c.layout {
+			t.Errorf("%v layout = %v, want %v", c.artifact, result.Layout, c.layout)
+		}
+		if got := listFiles(t, classDir); !reflect.DeepEqual(got, c.classes) {
+			t.Errorf("%v classes = %v, want %v", c.artifact, got, c.classes)
+		}
+
+		inputs, err := New(Options{}).Inputs(c.artifact)
+		if err != nil {
+			t.Errorf("inputs of %v error %v", c.artifact, err)
+			continue
+		}
+		var got []string
+		for _, input := range inputs {
+			rel, _ := filepath.Rel(dir, input)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if !reflect.DeepEqual(got, c.inputs) {
+			t.Errorf("%v inputs = %v, want %v", c.artifact, got, c.inputs)
+		}
+	}
+
+	if !IsAppDir(quarkus) || !IsAppDir(layered) || IsAppDir(filepath.Join(dir, "thin")) {
+		t.Errorf("app dirs not detected")
+	}
+}
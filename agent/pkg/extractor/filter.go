@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extractor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Filter selects the package dirs of classes and sources by the includes and excludes of a plan
+type Filter struct {
+	includes *regexp.Regexp
+	excludes *regexp.Regexp
+}
+
+// NewFilter returns the filter of colon separated globs, matching package dirs as com/example/order.
+// In a glob, ? matches a char and * matches any chars. Empty or * includes match all dirs.
+func NewFilter(includes string, excludes string) *Filter {
+	var f Filter
+	if includes != "*" {
+		f.includes = multiGlobToRegexp(includes)
+	}
+	if excludes != "*" {
+		f.excludes = multiGlobToRegexp(excludes)
+	}
+	return &f
+}
+
+// Match reports if the classes and sources of a package dir are kept
+func (f *Filter) Match(dir string) bool {
+	if f.includes != nil && !f.includes.MatchString(dir) {
+		return false
+	}
+	return f.excludes == nil || !f.excludes.MatchString(dir)
+}
+
+func multiGlobToRegexp(globs string) *regexp.Regexp {
+	var patterns []string
+	for _, glob := range strings.Split(globs, ":") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			patterns = append(patterns, "("+globToRegexp(glob)+")")
+		}
+	}
+	if len(patterns) <= 0 {
+		return nil
+	}
+	return regexp.MustCompile("^(" + strings.Join(patterns, "|") + ")$")
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for _, c := range glob {
+		switch c {
+		case '?':
+			b.WriteString(".")
+		case '*':
+			b.WriteString(".*")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
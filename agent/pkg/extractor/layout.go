@@ -0,0 +1,300 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// layouts of the artifacts
+const (
+	// BOOT-INF/classes and BOOT-INF/lib of a Spring Boot jar
+	LayoutSpringBoot = "spring-boot"
+	// a Spring Boot jar with BOOT-INF/layers.idx, or its layers extracted into dirs as application/BOOT-INF
+	LayoutSpringBootLayered = "spring-boot-layered"
+	// WEB-INF/classes and WEB-INF/lib of a war or an exploded webapp
+	LayoutWar = "war"
+	// the app/*.jar and lib/main/*.jar of a Quarkus fast-jar quarkus-app dir
+	LayoutQuarkus = "quarkus"
+	// an executable jar with its libraries bundled at the root, as the shade and shadow plugins and Micronaut build
+	LayoutShaded = "shaded"
+	// an executable jar with its libraries in the Class-Path of the manifest or in the lib dir beside it
+	LayoutThin = "thin"
+	// a dir of classes
+	LayoutClassDir = "class-dir"
+	// a jar without Main-Class, taken as a library of another artifact
+	LayoutLibrary = "library"
+	LayoutUnknown = "unknown"
+)
+
+const quarkusRunner = "quarkus-run.jar"
+
+// layout is where the classes of the application and its libraries are in an artifact
+type layout struct {
+	name    string
+	classes []classRoot
+	libs    []*archiveFile
+	// files and dirs besides the artifact read by the extraction
+	inputs []string
+	// archives opened for the layout, closed with it
+	opened []*archive
+}
+
+// classRoot is a dir of the classes of the application in an archive
+type classRoot struct {
+	archive *archive
+	prefix  string
+	// skips the classes of the libraries bundled in a shaded jar
+	skip func(name string) bool
+}
+
+func (l *layout) close() {
+	for _, a := range l.opened {
+		a.close()
+	}
+}
+
+// IsAppDir reports if a dir is an application made of many jars, as a Quarkus quarkus-app dir
+// or the extracted layers of a Spring Boot jar, so its jars are not artifacts by themselves
+func IsAppDir(dir string) bool {
+	if isFile(filepath.Join(dir, quarkusRunner)) && isDir(filepath.Join(dir, "lib", "main")) {
+		return true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && isDir(filepath.Join(dir, entry.Name(), "BOOT-INF")) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLayout finds the layout of an artifact opened as a
+func (e *Extractor) detectLayout(artifact string, a *archive) (*layout, error) {
+	switch {
+	case a.hasDir("BOOT-INF"):
+		name := LayoutSpringBoot
+		if _, ok := a.files["BOOT-INF/layers.idx"]; ok {
+			name = LayoutSpringBootLayered
+		}
+		return &layout{
+			name:    name,
+			classes: []classRoot{{archive: a, prefix: "BOOT-INF/classes/"}},
+			libs:    a.list("BOOT-INF/lib", "*.jar"),
+		}, nil
+	case a.hasDir("WEB-INF"):
+		return &layout{
+			name:    LayoutWar,
+			classes: []classRoot{{archive: a, prefix: "WEB-INF/classes/"}},
+			libs:    a.list("WEB-INF/lib", "*.jar"),
+		}, nil
+	}
+
+	if isDir(artifact) {
+		if _, ok := a.files[quarkusRunner]; ok && a.hasDir("lib/main") {
+			return e.quarkusLayout(a)
+		}
+		if layers := springBootLayers(a); len(layers) > 0 {
+			var l = layout{name: LayoutSpringBootLayered}
+			for _, layer := range layers {
+				l.classes = append(l.classes, classRoot{archive: a, prefix: layer + "/BOOT-INF/classes/"})
+				l.libs = append(l.libs, a.list(layer+"/BOOT-INF/lib", "*.jar")...)
+			}
+			return &l, nil
+		}
+		return &layout{name: LayoutClassDir, classes: []classRoot{{archive: a}}}, nil
+	}
+
+	if path.Base(artifact) == quarkusRunner && isDir(filepath.Join(filepath.Dir(artifact), "lib", "main")) {
+		dir, err := openArchive(filepath.Dir(artifact))
+		if err != nil {
+			return nil, err
+		}
+		l, err := e.quarkusLayout(dir)
+		if err != nil {
+			dir.close()
+			return nil, err
+		}
+		l.opened = append(l.opened, dir)
+		l.inputs = []string{filepath.Dir(artifact)}
+		return l, nil
+	}
+
+	manifest := readManifest(a)
+	if manifest["Main-Class"] == "" || !hasRootClasses(a) {
+		return &layout{name: LayoutLibrary}, nil
+	}
+	if skip := e.bundledClasses(a); skip != nil {
+		return &layout{name: LayoutShaded, classes: []classRoot{{archive: a, skip: skip}}}, nil
+	}
+	l := &layout{
+		name:    LayoutThin,
+		classes: []classRoot{{archive: a}},
+		libs:    thinLibraries(artifact, manifest["Class-Path"]),
+	}
+	for _, lib := range l.libs {
+		l.inputs = append(l.inputs, filepath.FromSlash(lib.name))
+	}
+	return l, nil
+}
+
+// quarkusLayout takes the classes of the app jars, the libraries are in lib/main
+func (e *Extractor) quarkusLayout(a *archive) (*layout, error) {
+	var l = layout{name: LayoutQuarkus, libs: a.list("lib/main", "*.jar")}
+	for _, f := range a.list("app", "*.jar") {
+		app, err := openNestedArchive(f)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		l.opened = append(l.opened, app)
+		l.classes = append(l.classes, classRoot{archive: app})
+	}
+	return &l, nil
+}
+
+// springBootLayers returns the dirs of the extracted layers of a Spring Boot jar
+func springBootLayers(a *archive) []string {
+	var layers []string
+	var seen = map[string]bool{}
+	for _, name := range a.names {
+		parts := strings.SplitN(name, "/", 3)
+		if len(parts) == 3 && parts[1] == "BOOT-INF" && !seen[parts[0]] {
+			seen[parts[0]] = true
+			layers = append(layers, parts[0])
+		}
+	}
+	return layers
+}
+
+// bundledClasses returns a skip of the classes of the bundled libraries if the jar is shaded:
+// it has the maven descriptors of many artifacts, or classes of the blacklisted groups or frameworks
+func (e *Extractor) bundledClasses(a *archive) func(name string) bool {
+	var packages []string
+	for _, prefix := range e.groupBlacklist(LayoutShaded) {
+		if strings.Contains(prefix, ".") {
+			packages = append(packages, strings.ReplaceAll(prefix, ".", "/")+"/")
+		}
+	}
+	skip := func(name string) bool {
+		for _, p := range packages {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	shaded := len(a.list("META-INF/maven", "pom.properties")) > 1
+	for _, name := range a.names {
+		if shaded {
+			break
+		}
+		shaded = strings.HasSuffix(name, ".class") && skip(name)
+	}
+	if !shaded {
+		return nil
+	}
+	return skip
+}
+
+// thinLibraries returns the jars of the Class-Path of a thin jar, or the jars of the lib dir beside it
+func thinLibraries(artifact string, classPath string) []*archiveFile {
+	var libs []*archiveFile
+	dir := filepath.Dir(artifact)
+	for _, entry := range strings.Fields(classPath) {
+		p := filepath.Join(dir, filepath.FromSlash(entry))
+		if strings.HasSuffix(entry, ".jar") && isFile(p) {
+			libs = append(libs, fileOf(p))
+		}
+	}
+	if len(libs) > 0 {
+		return libs
+	}
+
+	filepath.Walk(filepath.Join(dir, "lib"), func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() && strings.HasSuffix(p, ".jar") {
+			libs = append(libs, fileOf(p))
+		}
+		return nil
+	})
+	return libs
+}
+
+func fileOf(p string) *archiveFile {
+	return &archiveFile{name: filepath.ToSlash(p), open: func() (io.ReadCloser, error) {
+		return os.Open(p)
+	}}
+}
+
+func hasRootClasses(a *archive) bool {
+	for _, name := range a.names {
+		if strings.HasSuffix(name, ".class") && !strings.HasPrefix(name, "META-INF/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readManifest returns the main attributes of META-INF/MANIFEST.MF
+func readManifest(a *archive) map[string]string {
+	var attrs = map[string]string{}
+	f, ok := a.files["META-INF/MANIFEST.MF"]
+	if !ok {
+		return attrs
+	}
+	data, err := readFile(f)
+	if err != nil {
+		return attrs
+	}
+
+	var key string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			// the end of the main section
+			break
+		}
+		if strings.HasPrefix(line, " ") && key != "" {
+			attrs[key] += line[1:]
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			key = line[:i]
+			attrs[key] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return attrs
+}
+
+func isFile(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gitrepo keeps bare mirrors of remote git repositories with the git cli.
+package gitrepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Repo is a bare mirror of a remote repository in Dir
+type Repo struct {
+	URL string
+	Dir string
+}
+
+// commit ids of sha1 and sha256 repositories
+var commitRegex = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// checkURL rejects the urls git would take as an option, or run as a command with the ext transport
+func checkURL(url string) error {
+	lower := strings.ToLower(url)
+	if url == "" || strings.HasPrefix(url, "-") || strings.HasPrefix(lower, "ext::") || strings.HasPrefix(lower, "fd::") {
+		return fmt.Errorf("invalid repository url %v", redact(url))
+	}
+	return nil
+}
+
+// CheckGit checks the git cli the mirrors are kept with is in PATH
+func CheckGit() error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git not found in PATH, it is required to check out the application sources, error %v", err)
+	}
+	return nil
+}
+
+// Sync clones the remote repository into Dir, or fetches all refs if it is cloned already.
+// The url may come from an image label or an annotation, it is never taken as an option.
+func Sync(url string, dir string) (*Repo, error) {
+	if err := checkURL(url); err != nil {
+		return nil, err
+	}
+	repo := &Repo{URL: url, Dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		if _, err := repo.git("remote", "set-url", "--", "origin", url); err != nil {
+			return nil, err
+		}
+		if _, err := repo.git("fetch", "--prune", "origin"); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, err
+	}
+	if _, err := run("", "git", "-c", "protocol.ext.allow=never", "clone", "--mirror", "--", url, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return repo, nil
+}
+
+// ResolveRevision returns the commit id of revision, a revision is never taken as an option
+func (r *Repo) ResolveRevision(revision string) (string, error) {
+	out, err := r.git("rev-parse", "--verify", "--end-of-options", revision+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Diff returns the unified diff of the head revision against its merge base with the base revision,
+// the changes landed on base after the branch point are not in it
+func (r *Repo) Diff(base string, head string) ([]byte, error) {
+	baseCommit, err := r.ResolveRevision(base)
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.ResolveRevision(head)
+	if err != nil {
+		return nil, err
+	}
+	return r.git("diff", "--no-color", "--no-ext-diff", "--unified=0", baseCommit+"..."+headCommit, "--")
+}
+
+// Archive writes the files of a commit into the paths returned by dest, the files dest returns false for are skipped.
+// The commit is an id returned by ResolveRevision.
+func (r *Repo) Archive(commit string, dest func(name string) (string, bool)) error {
+	if !commitRegex.MatchString(commit) {
+		return fmt.Errorf("invalid commit id %v", commit)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "--git-dir", r.Dir, "archive", "--format=tar", "--end-of-options", commit)
+	cmd.Dir = r.Dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = extractTar(stdout, dest)
+	// drains the archive so git does not block on a skipped tail
+	io.Copy(ioutil.Discard, stdout)
+	if waitErr := cmd.Wait(); waitErr != nil {
+		return fmt.Errorf("git archive %v error %v: %v", commit, waitErr, redact(strings.TrimSpace(stderr.String())))
+	}
+	return err
+}
+
+func extractTar(r io.Reader, dest func(name string) (string, bool)) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		p, ok := dest(header.Name)
+		if !ok {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Repo) git(args ...string) ([]byte, error) {
+	return run(r.Dir, "git", append([]string{"-c", "protocol.ext.allow=never", "--git-dir", r.Dir}, args...)...)
+}
+
+func run(dir string, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v %v error %v: %v", name, redact(strings.Join(args, " ")), err, redact(strings.TrimSpace(stderr.String())))
+	}
+	return stdout.Bytes(), nil
+}
+
+var credentialsRegex = regexp.MustCompile(`://[^/@\s]+@`)
+
+// redact hides the credentials of repository urls in messages
+func redact(s string) string {
+	return credentialsRegex.ReplaceAllString(s, "://***@")
+}
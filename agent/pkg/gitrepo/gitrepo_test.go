@@ -0,0 +1,190 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testCommit writes the files into the work tree and commits them, returning the commit id
+func testCommit(t *testing.T, work string, files map[string]string) string {
+	for name, content := range files {
+		p := filepath.Join(work, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testGit(t, work, "add", "-A")
+	testGit(t, work, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "commit")
+	return strings.TrimSpace(testGit(t, work, "rev-parse", "HEAD"))
+}
+
+func testGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v error %v: %s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func TestSyncArchive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "gitrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	work := filepath.Join(dir, "work")
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, work, "init", "-q")
+	first := testCommit(t, work, map[string]string{
+		"order/src/main/java/com/example/Order.java": "class Order {}",
+		"order/pom.xml": "<project/>",
+	})
+	remote := filepath.Join(dir, "remote.git")
+	testGit(t, dir, "clone", "-q", "--bare", work, remote)
+
+	mirror := filepath.Join(dir, "mirror")
+	repo, err := Sync("file://"+remote, mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new commit is fetched by the next sync
+	second := testCommit(t, work, map[string]string{
+		"order/src/main/java/com/example/Order.java": "class Order { int id; }",
+	})
+	testGit(t, work, "push", "-q", remote, "HEAD:refs/tags/v2")
+	if _, err := repo.ResolveRevision("v2"); err == nil {
+		t.Fatalf("v2 resolved before sync")
+	}
+	if repo, err = Sync("file://"+remote, mirror); err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.ResolveRevision("v2")
+	if err != nil || commit != second {
+		t.Fatalf("v2 = %v, %v, want %v", commit, err, second)
+	}
+
+	out := filepath.Join(dir, "out")
+	err = repo.Archive(first, func(name string) (string, bool) {
+		rel := strings.TrimPrefix(name, "order/src/main/java/")
+		if rel == name {
+			return "", false
+		}
+		return filepath.Join(out, rel), true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(out, "com", "example", "Order.java"))
+	if err != nil || string(data) != "class Order {}" {
+		t.Errorf("archived source = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "pom.xml")); err == nil {
+		t.Errorf("skipped file archived")
+	}
+
+	// only the commit ids resolved are archived
+	for _, revision := range []string{"missing", "--output=" + filepath.Join(dir, "output"), "HEAD", strings.Repeat("0", 40)} {
+		if err := repo.Archive(revision, func(name string) (string, bool) { return "", false }); err == nil {
+			t.Errorf("archive of %v succeeded", revision)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "output")); err == nil {
+		t.Errorf("option revision wrote a file")
+	}
+
+	// the urls of labels and annotations are never taken as options or commands
+	marker := filepath.Join(dir, "marker")
+	for _, url := range []string{"--upload-pack=touch " + marker, "-u touch " + marker, "ext::sh -c touch% " + marker, "EXT::sh", ""} {
+		if _, err := Sync(url, filepath.Join(dir, "injected")); err == nil {
+			t.Errorf("sync of %q succeeded", url)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("url ran a command")
+	}
+
+	diff, err := repo.Diff(first, second)
+	if err != nil || !strings.Contains(string(diff), "+class Order { int id; }") {
+		t.Errorf("diff = %s, %v", diff, err)
+	}
+}
+
+func TestDiffMergeBase(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "gitrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	work := filepath.Join(dir, "work")
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, work, "init", "-q")
+	testCommit(t, work, map[string]string{"Order.java": "class Order {}", "User.java": "class User {}"})
+	testGit(t, work, "branch", "-q", "base")
+	testCommit(t, work, map[string]string{"Order.java": "class Order { int id; }"})
+	testGit(t, work, "checkout", "-q", "base")
+	// landed on base after the branch point
+	testCommit(t, work, map[string]string{"User.java": "class User { int id; }"})
+	testGit(t, work, "checkout", "-q", "-")
+
+	repo, err := Sync("file://"+work, filepath.Join(dir, "mirror"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := strings.TrimSpace(testGit(t, work, "rev-parse", "HEAD"))
+	diff, err := repo.Diff("base", head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(diff), "+class Order { int id; }") || strings.Contains(string(diff), "User.java") {
+		t.Errorf("diff = %s", diff)
+	}
+
+	output := filepath.Join(dir, "output")
+	for _, revision := range []string{"--output=" + output, "-p"} {
+		if _, err := repo.ResolveRevision(revision); err == nil {
+			t.Errorf("option %v resolved as a revision", revision)
+		}
+		if _, err := repo.Diff(revision, head); err == nil {
+			t.Errorf("option %v taken as the base revision", revision)
+		}
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Errorf("option revision wrote %v", output)
+	}
+}
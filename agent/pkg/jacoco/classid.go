@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import "hash/crc64"
+
+// JaCoCo takes the CRC64 of the class bytes with the reversed ISO polynomial and no inversion as the class id,
+// see org.jacoco.core.internal.data.CRC64
+var classIDTable = crc64.MakeTable(crc64.ISO)
+
+// the major version of the java 9 class files, instrumented as java 8 class files by the early agents
+const java9MajorVersion = 53
+
+// ClassID returns the id of the execution data of a class file
+func ClassID(class []byte) uint64 {
+	if len(class) > 7 && class[6] == 0x00 && class[7] == java9MajorVersion {
+		// jacoco takes java 9 class files as java 8 ones for the id, as its early java 9 support did
+		sum := updateClassID(0, class[:7])
+		sum = updateClassID(sum, []byte{java9MajorVersion - 1})
+		return updateClassID(sum, class[8:])
+	}
+	return updateClassID(0, class)
+}
+
+// updateClassID updates the crc without the inversions of hash/crc64
+func updateClassID(sum uint64, p []byte) uint64 {
+	return ^crc64.Update(^sum, classIDTable, p)
+}
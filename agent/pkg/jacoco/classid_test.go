@@ -0,0 +1,55 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import "testing"
+
+// bitwiseClassID is the CRC64 of org.jacoco.core.internal.data.CRC64 computed bit by bit
+func bitwiseClassID(p []byte) uint64 {
+	var sum uint64
+	for _, b := range p {
+		v := (sum ^ uint64(b)) & 0xff
+		for i := 0; i < 8; i++ {
+			if v&1 == 1 {
+				v = v>>1 ^ 0xD800000000000000
+			} else {
+				v >>= 1
+			}
+		}
+		sum = sum>>8 ^ v
+	}
+	return sum
+}
+
+func TestClassID(t *testing.T) {
+	java8 := []byte{0xCA, 0xFE, 0xBA, 0xBE, 0x00, 0x00, 0x00, 52, 0x00, 0x10, 0x0A, 0x00}
+	java9 := []byte{0xCA, 0xFE, 0xBA, 0xBE, 0x00, 0x00, 0x00, 53, 0x00, 0x10, 0x0A, 0x00}
+	java11 := []byte{0xCA, 0xFE, 0xBA, 0xBE, 0x00, 0x00, 0x00, 55, 0x00, 0x10, 0x0A, 0x00}
+
+	if id := ClassID(nil); id != 0 {
+		t.Errorf("id of empty class = %016x", id)
+	}
+	for _, class := range [][]byte{java8, java11, []byte("a class file longer than the 64 bytes hashed by slices of 8 bytes at once")} {
+		if id, want := ClassID(class), bitwiseClassID(class); id != want {
+			t.Errorf("id = %016x, want %016x", id, want)
+		}
+	}
+	if ClassID(java9) != ClassID(java8) {
+		t.Errorf("java 9 class id %016x, want the java 8 one %016x", ClassID(java9), ClassID(java8))
+	}
+	if ClassID(java11) == ClassID(java8) {
+		t.Errorf("java 11 class id is the java 8 one")
+	}
+}
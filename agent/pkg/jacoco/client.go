@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const DefaultPort = 6300
+
+// operations of a dump, used in DumpError
+const (
+	OpDial    = "dial"
+	OpSend    = "send"
+	OpReceive = "receive"
+	OpWrite   = "write"
+)
+
+// DumpError is returned when the dump of one jvm fails
+type DumpError struct {
+	Addr string
+	Op   string
+	Err  error
+}
+
+func (e *DumpError) Error() string {
+	return fmt.Sprintf("dump %v %v error %v", e.Addr, e.Op, e.Err)
+}
+
+func (e *DumpError) Unwrap() error {
+	return e.Err
+}
+
+// Client talks the remote control protocol of the jacoco agent in tcpserver mode,
+// replacement of `jacococli dump`
+type Client struct {
+	DialTimeout time.Duration
+	// Timeout is the deadline of the whole exchange after the connection is established
+	Timeout time.Duration
+}
+
+var DefaultClient = &Client{
+	DialTimeout: 5 * time.Second,
+	Timeout:     2 * time.Minute,
+}
+
+// Dump requests the execution data of the jvm at addr and streams it into w in exec format
+func (c *Client) Dump(addr string, reset bool, w io.Writer) error {
+	conn, err := net.DialTimeout("tcp", addr, c.DialTimeout)
+	if err != nil {
+		return &DumpError{Addr: addr, Op: OpDial, Err: err}
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	remote, err := NewWriter(conn)
+	if err != nil {
+		return &DumpError{Addr: addr, Op: OpSend, Err: err}
+	}
+	if err := remote.WriteDumpCommand(true, reset); err != nil {
+		return &DumpError{Addr: addr, Op: OpSend, Err: err}
+	}
+	if err := remote.Flush(); err != nil {
+		return &DumpError{Addr: addr, Op: OpSend, Err: err}
+	}
+
+	writer, err := NewWriter(w)
+	if err != nil {
+		return &DumpError{Addr: addr, Op: OpWrite, Err: err}
+	}
+	reader := NewReader(conn)
+	for {
+		block, err := reader.ReadBlock()
+		if err == io.EOF {
+			return &DumpError{Addr: addr, Op: OpReceive, Err: fmt.Errorf("socket closed unexpectedly")}
+		}
+		if err != nil {
+			return &DumpError{Addr: addr, Op: OpReceive, Err: err}
+		}
+
+		switch b := block.(type) {
+		case *SessionInfo:
+			err = writer.WriteSessionInfo(b)
+		case *ExecutionData:
+			err = writer.WriteExecutionData(b)
+		case *CmdOk:
+			if err := writer.Flush(); err != nil {
+				return &DumpError{Addr: addr, Op: OpWrite, Err: err}
+			}
+			return nil
+		default:
+			err = fmt.Errorf("unexpected block %T", block)
+		}
+		if err != nil {
+			return &DumpError{Addr: addr, Op: OpWrite, Err: err}
+		}
+	}
+}
+
+// DumpToFile dumps the jvm at addr into the exec file at path
+func (c *Client) DumpToFile(addr string, path string, reset bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return &DumpError{Addr: addr, Op: OpWrite, Err: err}
+	}
+	err = c.Dump(addr, reset, f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		return &DumpError{Addr: addr, Op: OpWrite, Err: closeErr}
+	}
+	return err
+}
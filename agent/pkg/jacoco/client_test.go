@@ -0,0 +1,101 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeAgent serves one connection like the jacoco agent in tcpserver mode
+func fakeAgent(t *testing.T, closeEarly bool) (string, <-chan *DumpCommand) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	commands := make(chan *DumpCommand, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		writer, _ := NewWriter(conn)
+		writer.Flush()
+		block, err := NewReader(conn).ReadBlock()
+		if err != nil {
+			return
+		}
+		commands <- block.(*DumpCommand)
+		if closeEarly {
+			return
+		}
+		writer.WriteSessionInfo(&SessionInfo{ID: "pod", Start: 1, Dump: 2})
+		writer.WriteExecutionData(&ExecutionData{ID: 7, Name: "a/A", Probes: []bool{false, true}})
+		writer.writeByte(BlockCmdOk)
+		writer.Flush()
+	}()
+	return l.Addr().String(), commands
+}
+
+func TestClientDump(t *testing.T) {
+	addr, commands := fakeAgent(t, false)
+
+	var buf bytes.Buffer
+	if err := DefaultClient.Dump(addr, true, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-commands; !cmd.Dump || !cmd.Reset {
+		t.Errorf("command = %+v, want dump and reset", cmd)
+	}
+
+	data := NewExecData()
+	if err := NewReader(&buf).ReadInto(data); err != nil {
+		t.Fatal(err)
+	}
+	want := []*ExecutionData{{ID: 7, Name: "a/A", Probes: []bool{false, true}}}
+	if !reflect.DeepEqual(data.Classes(), want) {
+		t.Errorf("classes = %v, want %v", data.Classes(), want)
+	}
+	if len(data.Sessions) != 1 || data.Sessions[0].ID != "pod" {
+		t.Errorf("sessions = %v", data.Sessions)
+	}
+}
+
+func TestClientDumpErrors(t *testing.T) {
+	addr, _ := fakeAgent(t, true)
+	var dumpErr *DumpError
+	err := DefaultClient.Dump(addr, false, &bytes.Buffer{})
+	if !errors.As(err, &dumpErr) || dumpErr.Op != OpReceive {
+		t.Errorf("err = %v, want receive error", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+	err = DefaultClient.Dump(closedAddr, false, &bytes.Buffer{})
+	if !errors.As(err, &dumpErr) || dumpErr.Op != OpDial {
+		t.Errorf("err = %v, want dial error", err)
+	}
+}
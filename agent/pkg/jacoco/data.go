@@ -0,0 +1,207 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jacoco reads and writes the JaCoCo exec binary format.
+package jacoco
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// block types of the exec format, see org.jacoco.core.data.ExecutionDataWriter
+const (
+	BlockHeader        byte = 0x01
+	BlockSessionInfo   byte = 0x10
+	BlockExecutionData byte = 0x11
+	BlockCmdOk         byte = 0x20
+	BlockCmdDump       byte = 0x40
+)
+
+const (
+	MagicNumber   uint16 = 0xC0C0
+	FormatVersion uint16 = 0x1007
+)
+
+// SessionInfo describes one coverage session of a jvm
+type SessionInfo struct {
+	ID    string
+	Start int64
+	Dump  int64
+}
+
+// ExecutionData is the probe data of one class
+type ExecutionData struct {
+	ID     uint64
+	Name   string
+	Probes []bool
+}
+
+// Merge ors the probes of other into d, both must describe the same class
+func (d *ExecutionData) Merge(other *ExecutionData) error {
+	if d.ID != other.ID {
+		return fmt.Errorf("different class ids %016x and %016x", d.ID, other.ID)
+	}
+	if d.Name != other.Name {
+		return fmt.Errorf("different class names %v and %v for id %016x", d.Name, other.Name, d.ID)
+	}
+	if len(d.Probes) != len(other.Probes) {
+		return fmt.Errorf("incompatible execution data for class %v with id %016x", d.Name, d.ID)
+	}
+	for i, hit := range other.Probes {
+		if hit {
+			d.Probes[i] = true
+		}
+	}
+	return nil
+}
+
+// Covered reports whether any probe of the class was hit
+func (d *ExecutionData) Covered() bool {
+	for _, hit := range d.Probes {
+		if hit {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecData is the content of an exec file, execution data of the same class id is merged
+type ExecData struct {
+	Sessions []SessionInfo
+	classes  map[uint64]*ExecutionData
+}
+
+func NewExecData() *ExecData {
+	return &ExecData{classes: map[uint64]*ExecutionData{}}
+}
+
+func (e *ExecData) AddSession(info SessionInfo) {
+	e.Sessions = append(e.Sessions, info)
+}
+
+// AddClass stores a copy of data, or merges it into the data already stored for the class id
+func (e *ExecData) AddClass(data *ExecutionData) error {
+	if exist, ok := e.classes[data.ID]; ok {
+		return exist.Merge(data)
+	}
+	probes := make([]bool, len(data.Probes))
+	copy(probes, data.Probes)
+	e.classes[data.ID] = &ExecutionData{ID: data.ID, Name: data.Name, Probes: probes}
+	return nil
+}
+
+// Class returns the execution data of the class id
+func (e *ExecData) Class(id uint64) (*ExecutionData, bool) {
+	data, ok := e.classes[id]
+	return data, ok
+}
+
+// Classes returns all execution data ordered by class name
+func (e *ExecData) Classes() []*ExecutionData {
+	var list []*ExecutionData
+	for _, data := range e.classes {
+		list = append(list, data)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Merge adds all sessions and execution data of other into e
+func (e *ExecData) Merge(other *ExecData) error {
+	for _, info := range other.Sessions {
+		e.AddSession(info)
+	}
+	for _, data := range other.Classes() {
+		if err := e.AddClass(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile reads an exec file, an empty file is read as empty data like jacoco does
+func ReadFile(path string) (*ExecData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := NewExecData()
+	if err := NewReader(f).ReadInto(data); err != nil {
+		return nil, fmt.Errorf("read exec file %v error %v", path, err)
+	}
+	return data, nil
+}
+
+// WriteFile writes e to path, sessions are ordered by start time like jacococli merge does
+func (e *ExecData) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := e.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes e in exec format to the writer
+func (e *ExecData) Write(w io.Writer) error {
+	writer, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	sessions := make([]SessionInfo, len(e.Sessions))
+	copy(sessions, e.Sessions)
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start < sessions[j].Start
+	})
+	for i := range sessions {
+		if err := writer.WriteSessionInfo(&sessions[i]); err != nil {
+			return err
+		}
+	}
+	for _, data := range e.Classes() {
+		if err := writer.WriteExecutionData(data); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// MergeFiles merges all exec files into destFile, replacement of `jacococli merge`
+func MergeFiles(destFile string, files []string) error {
+	merged := NewExecData()
+	for _, file := range files {
+		data, err := ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := merged.Merge(data); err != nil {
+			return fmt.Errorf("merge exec file %v error %v", file, err)
+		}
+	}
+	return merged.WriteFile(destFile)
+}
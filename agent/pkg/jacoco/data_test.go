@@ -0,0 +1,122 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// an exec file with one session and one class with probes [true, false, true]
+var execBytes = []byte{
+	0x01, 0xC0, 0xC0, 0x10, 0x07,
+	0x10, 0x00, 0x02, 'i', 'd',
+	0, 0, 0, 0, 0, 0, 0, 1,
+	0, 0, 0, 0, 0, 0, 0, 2,
+	0x11, 0, 0, 0, 0, 0, 0, 0, 0x2A,
+	0x00, 0x05, 'a', '/', 'B', 'a', 'r',
+	0x03, 0x05,
+}
+
+func TestReadWrite(t *testing.T) {
+	data := NewExecData()
+	if err := NewReader(bytes.NewReader(execBytes)).ReadInto(data); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSessions := []SessionInfo{{ID: "id", Start: 1, Dump: 2}}
+	if !reflect.DeepEqual(data.Sessions, wantSessions) {
+		t.Errorf("sessions = %v, want %v", data.Sessions, wantSessions)
+	}
+	wantClasses := []*ExecutionData{{ID: 0x2A, Name: "a/Bar", Probes: []bool{true, false, true}}}
+	if !reflect.DeepEqual(data.Classes(), wantClasses) {
+		t.Errorf("classes = %v, want %v", data.Classes(), wantClasses)
+	}
+
+	var buf bytes.Buffer
+	if err := data.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), execBytes) {
+		t.Errorf("written = %x, want %x", buf.Bytes(), execBytes)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"no header", []byte{0x10, 0x00, 0x00}},
+		{"bad magic", []byte{0x01, 0xC0, 0xC1, 0x10, 0x07}},
+		{"bad version", []byte{0x01, 0xC0, 0xC0, 0x10, 0x06}},
+		{"truncated", execBytes[:len(execBytes)-1]},
+		{"too many probes", []byte{0x01, 0xC0, 0xC0, 0x10, 0x07, 0x11, 0, 0, 0, 0, 0, 0, 0, 0x2A, 0x00, 0x01, 'A', 0xFF, 0xFF, 0xFF, 0xFF, 0x0F}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewReader(bytes.NewReader(tt.input)).ReadInto(NewExecData()); err == nil {
+				t.Errorf("want error")
+			}
+		})
+	}
+}
+
+func TestMergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := NewExecData()
+	first.AddSession(SessionInfo{ID: "pod-1", Start: 2, Dump: 3})
+	first.AddClass(&ExecutionData{ID: 1, Name: "a/A", Probes: []bool{true, false, false, false, false, false, false, false, false}})
+	second := NewExecData()
+	second.AddSession(SessionInfo{ID: "pod-2", Start: 1, Dump: 3})
+	second.AddClass(&ExecutionData{ID: 1, Name: "a/A", Probes: []bool{false, false, false, false, false, false, false, false, true}})
+	second.AddClass(&ExecutionData{ID: 2, Name: "a/B", Probes: []bool{false}})
+
+	files := []string{filepath.Join(dir, "1.exec"), filepath.Join(dir, "2.exec")}
+	if err := first.WriteFile(files[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.WriteFile(files[1]); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "merged.exec")
+	if err := MergeFiles(dest, files); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Sessions) != 2 || merged.Sessions[0].ID != "pod-2" {
+		t.Errorf("sessions = %v, want ordered by start", merged.Sessions)
+	}
+	a, _ := merged.Class(1)
+	wantProbes := []bool{true, false, false, false, false, false, false, false, true}
+	if !reflect.DeepEqual(a.Probes, wantProbes) {
+		t.Errorf("probes = %v, want %v", a.Probes, wantProbes)
+	}
+	if _, ok := merged.Class(2); !ok {
+		t.Errorf("class a/B missing")
+	}
+
+	incompatible := NewExecData()
+	incompatible.AddClass(&ExecutionData{ID: 1, Name: "a/A", Probes: []bool{true}})
+	if err := merged.Merge(incompatible); err == nil {
+		t.Errorf("want error merging different probe counts")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// DumpCommand is the remote control command sent to a jacoco tcpserver
+type DumpCommand struct {
+	Dump  bool
+	Reset bool
+}
+
+// CmdOk marks the end of the response of a remote control command
+type CmdOk struct{}
+
+// Reader reads blocks written by Writer or by jacoco itself
+type Reader struct {
+	r          *bufio.Reader
+	firstBlock bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), firstBlock: true}
+}
+
+// ReadBlock returns the next *SessionInfo, *ExecutionData, *DumpCommand or *CmdOk block.
+// Header blocks are validated and skipped, io.EOF is returned at the end of input.
+func (r *Reader) ReadBlock() (interface{}, error) {
+	for {
+		blockType, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if r.firstBlock && blockType != BlockHeader {
+			return nil, fmt.Errorf("invalid execution data file")
+		}
+		r.firstBlock = false
+
+		switch blockType {
+		case BlockHeader:
+			if err := r.readHeader(); err != nil {
+				return nil, err
+			}
+		case BlockSessionInfo:
+			return r.readSessionInfo()
+		case BlockExecutionData:
+			return r.readExecutionData()
+		case BlockCmdDump:
+			dump, err := r.readBoolean()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			reset, err := r.readBoolean()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			return &DumpCommand{Dump: dump, Reset: reset}, nil
+		case BlockCmdOk:
+			return &CmdOk{}, nil
+		default:
+			return nil, fmt.Errorf("unknown block type %x", blockType)
+		}
+	}
+}
+
+// ReadInto reads all blocks until the end of input into data
+func (r *Reader) ReadInto(data *ExecData) error {
+	for {
+		block, err := r.ReadBlock()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := data.add(block); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *ExecData) add(block interface{}) error {
+	switch b := block.(type) {
+	case *SessionInfo:
+		e.AddSession(*b)
+	case *ExecutionData:
+		return e.AddClass(b)
+	default:
+		return fmt.Errorf("unexpected block %T in execution data", block)
+	}
+	return nil
+}
+
+func (r *Reader) readHeader() error {
+	magic, err := r.readChar()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if magic != MagicNumber {
+		return fmt.Errorf("invalid execution data file")
+	}
+	version, err := r.readChar()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if version != FormatVersion {
+		return fmt.Errorf("incompatible version %x", version)
+	}
+	return nil
+}
+
+func (r *Reader) readSessionInfo() (*SessionInfo, error) {
+	id, err := r.readUTF()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	start, err := r.readLong()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	dump, err := r.readLong()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &SessionInfo{ID: id, Start: start, Dump: dump}, nil
+}
+
+func (r *Reader) readExecutionData() (*ExecutionData, error) {
+	id, err := r.readLong()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	name, err := r.readUTF()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	probes, err := r.readBooleanArray()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &ExecutionData{ID: uint64(id), Name: name, Probes: probes}, nil
+}
+
+func (r *Reader) readBoolean() (bool, error) {
+	b, err := r.r.ReadByte()
+	return b != 0, err
+}
+
+func (r *Reader) readChar() (uint16, error) {
+	var buf [2]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buf[:]), nil
+}
+
+func (r *Reader) readLong() (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+// readUTF reads a string in java modified UTF-8
+func (r *Reader) readUTF() (string, error) {
+	length, err := r.readChar()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", err
+	}
+
+	var chars []uint16
+	for i := 0; i < len(buf); {
+		c := uint16(buf[i])
+		switch {
+		case c < 0x80:
+			i++
+		case c&0xE0 == 0xC0 && i+1 < len(buf):
+			c = (c&0x1F)<<6 | uint16(buf[i+1]&0x3F)
+			i += 2
+		case c&0xF0 == 0xE0 && i+2 < len(buf):
+			c = (c&0x0F)<<12 | uint16(buf[i+1]&0x3F)<<6 | uint16(buf[i+2]&0x3F)
+			i += 3
+		default:
+			return "", fmt.Errorf("malformed modified utf-8 string")
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars)), nil
+}
+
+func (r *Reader) readVarInt() (uint32, error) {
+	var value uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("malformed varint")
+}
+
+// maxProbes bounds the probes of a class, far above what the byte code of a class file can hold
+const maxProbes = 1 << 24
+
+func (r *Reader) readBooleanArray() ([]bool, error) {
+	length, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if length > maxProbes {
+		return nil, fmt.Errorf("probe count %d over %d", length, maxProbes)
+	}
+	values := make([]bool, length)
+	var buffer byte
+	for i := range values {
+		if i%8 == 0 {
+			buffer, err = r.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+		}
+		values[i] = buffer&0x01 != 0
+		buffer >>= 1
+	}
+	return values, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jacoco
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// Writer writes blocks in the format of java DataOutputStream used by jacoco
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter creates a writer and writes the file header, like ExecutionDataWriter does
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{w: bufio.NewWriter(w)}
+	if err := writer.WriteHeader(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *Writer) WriteHeader() error {
+	w.writeByte(BlockHeader)
+	w.writeChar(MagicNumber)
+	return w.writeChar(FormatVersion)
+}
+
+func (w *Writer) WriteSessionInfo(info *SessionInfo) error {
+	w.writeByte(BlockSessionInfo)
+	if err := w.writeUTF(info.ID); err != nil {
+		return err
+	}
+	w.writeLong(info.Start)
+	return w.writeLong(info.Dump)
+}
+
+func (w *Writer) WriteExecutionData(data *ExecutionData) error {
+	w.writeByte(BlockExecutionData)
+	w.writeLong(int64(data.ID))
+	if err := w.writeUTF(data.Name); err != nil {
+		return err
+	}
+	return w.writeBooleanArray(data.Probes)
+}
+
+// WriteDumpCommand writes the remote control command of the jacoco tcpserver
+func (w *Writer) WriteDumpCommand(dump, reset bool) error {
+	w.writeByte(BlockCmdDump)
+	w.writeBoolean(dump)
+	return w.writeBoolean(reset)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// the bufio.Writer keeps the first error, so only the last write of a block returns it
+func (w *Writer) writeByte(b byte) error {
+	return w.w.WriteByte(b)
+}
+
+func (w *Writer) writeBoolean(v bool) error {
+	if v {
+		return w.writeByte(1)
+	}
+	return w.writeByte(0)
+}
+
+func (w *Writer) writeChar(v uint16) error {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	_, err := w.w.Write(buf[:])
+	return err
+}
+
+func (w *Writer) writeLong(v int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	_, err := w.w.Write(buf[:])
+	return err
+}
+
+// writeUTF writes a string in java modified UTF-8
+func (w *Writer) writeUTF(s string) error {
+	var encoded []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c >= 0x0001 && c <= 0x007F:
+			encoded = append(encoded, byte(c))
+		case c <= 0x07FF:
+			encoded = append(encoded, byte(0xC0|(c>>6)&0x1F), byte(0x80|c&0x3F))
+		default:
+			encoded = append(encoded, byte(0xE0|(c>>12)&0x0F), byte(0x80|(c>>6)&0x3F), byte(0x80|c&0x3F))
+		}
+	}
+	if len(encoded) > 0xFFFF {
+		return fmt.Errorf("string too long to encode: %d bytes", len(encoded))
+	}
+	w.writeChar(uint16(len(encoded)))
+	_, err := w.w.Write(encoded)
+	return err
+}
+
+func (w *Writer) writeVarInt(v uint32) error {
+	for v&0xFFFFFF80 != 0 {
+		w.writeByte(byte(v&0x7F | 0x80))
+		v >>= 7
+	}
+	return w.writeByte(byte(v))
+}
+
+// writeBooleanArray writes the probes packed into bytes, see CompactDataOutput
+func (w *Writer) writeBooleanArray(values []bool) error {
+	w.writeVarInt(uint32(len(values)))
+	var buffer, size byte
+	for _, v := range values {
+		if v {
+			buffer |= 1 << size
+		}
+		size++
+		if size == 8 {
+			w.writeByte(buffer)
+			buffer, size = 0, 0
+		}
+	}
+	if size > 0 {
+		return w.writeByte(buffer)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maven
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testSettings = `<settings>
+  <mirrors>
+    <mirror>
+      <id>nexus</id>
+      <mirrorOf>*,!snapshots</mirrorOf>
+      <url>%URL%/public/</url>
+    </mirror>
+  </mirrors>
+  <servers>
+    <server>
+      <id>nexus</id>
+      <username>deployer</username>
+      <password>${env.MAVEN_TEST_PASSWORD}</password>
+    </server>
+    <server>
+      <id>snapshots</id>
+      <username>deployer</username>
+      <password>${env.MAVEN_TEST_PASSWORD}</password>
+    </server>
+  </servers>
+  <profiles>
+    <profile>
+      <id>dev</id>
+      <repositories>
+        <repository>
+          <id>snapshots</id>
+          <url>%URL%/snapshots</url>
+          <releases><enabled>false</enabled></releases>
+        </repository>
+      </repositories>
+    </profile>
+  </profiles>
+  <activeProfiles>
+    <activeProfile>dev</activeProfile>
+  </activeProfiles>
+</settings>`
+
+func sha1Hex(data string) string {
+	sum := sha1.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// testRepository serves files by path with basic auth and counts the requests
+type testRepository struct {
+	files    map[string]string
+	lock     sync.Mutex
+	requests map[string]int
+}
+
+func (r *testRepository) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	r.requests[req.URL.Path]++
+	r.lock.Unlock()
+	if user, password, ok := req.BasicAuth(); !ok || user != "deployer" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	data, ok := r.files[req.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(data))
+}
+
+func (r *testRepository) count(p string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.requests[p]
+}
+
+func newTestResolver(t *testing.T, repo *testRepository) (*Resolver, func()) {
+	server := httptest.NewServer(repo)
+	os.Setenv("MAVEN_TEST_PASSWORD", "secret")
+	settings, err := ParseSettings([]byte(strings.ReplaceAll(testSettings, "%URL%", server.URL)))
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "maven")
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return NewResolver(settings, dir), func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSettingsRepositories(t *testing.T) {
+	settings, err := ParseSettings([]byte(strings.ReplaceAll(testSettings, "%URL%", "http://nexus")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := settings.Repositories()
+	if len(repos) != 2 {
+		t.Fatalf("repositories = %+v", repos)
+	}
+	if repos[0].ID != "snapshots" || repos[0].URL != "http://nexus/snapshots" || repos[0].Releases.enabled() {
+		t.Errorf("snapshots repository = %+v", repos[0])
+	}
+	if repos[1].ID != "nexus" || repos[1].URL != "http://nexus/public" || repos[1].Snapshots.enabled() {
+		t.Errorf("central mirror = %+v", repos[1])
+	}
+
+	for mirrorOf, want := range map[string]bool{
+		"*":                      true,
+		"central":                true,
+		"external:*":             true,
+		"releases,central":       true,
+		"*,!central":             false,
+		"releases":               false,
+		"external:*,!releases":   true,
+		"external:*,!central,*":  false,
+		" releases , central ":   true,
+		"snapshots,!central,any": false,
+	} {
+		got := matchMirrorOf(mirrorOf, Repository{ID: "central", URL: CentralURL})
+		if got != want {
+			t.Errorf("mirrorOf %q = %v, want %v", mirrorOf, got, want)
+		}
+	}
+	if matchMirrorOf("external:*", Repository{ID: "local", URL: "file:///repo"}) {
+		t.Errorf("external:* matched a file repository")
+	}
+}
+
+func TestResolveRelease(t *testing.T) {
+	const content = "sources"
+	repo := &testRepository{
+		files: map[string]string{
+			"/public/com/example/lib/1.0/lib-1.0-sources.jar":      content,
+			"/public/com/example/lib/1.0/lib-1.0-sources.jar.sha1": sha1Hex(content) + "  lib-1.0-sources.jar",
+			"/public/com/example/bad/1.0/bad-1.0-sources.jar":      content,
+			"/public/com/example/bad/1.0/bad-1.0-sources.jar.sha1": sha1Hex("other"),
+		},
+		requests: map[string]int{},
+	}
+	r, done := newTestResolver(t, repo)
+	defer done()
+
+	artifact := Artifact{GroupID: "com.example", ArtifactID: "lib", Version: "1.0", Classifier: "sources"}
+	for i := 0; i < 2; i++ {
+		p, err := r.Resolve(artifact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil || string(data) != content {
+			t.Fatalf("resolved %v = %q, %v", p, data, err)
+		}
+	}
+	if n := repo.count("/public/com/example/lib/1.0/lib-1.0-sources.jar"); n != 1 {
+		t.Errorf("downloaded %v times, want from the local repository", n)
+	}
+	// releases are disabled in the snapshots repository
+	if n := repo.count("/snapshots/com/example/lib/1.0/lib-1.0-sources.jar"); n != 0 {
+		t.Errorf("release asked from the snapshots repository")
+	}
+
+	_, err := r.Resolve(Artifact{GroupID: "com.example", ArtifactID: "bad", Version: "1.0", Classifier: "sources"})
+	if err == nil || err == ErrNotFound || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("checksum mismatch error = %v", err)
+	}
+}
+
+func TestResolveSnapshot(t *testing.T) {
+	const metadata = `<metadata>
+  <versioning>
+    <snapshot><timestamp>20210801.101010</timestamp><buildNumber>3</buildNumber></snapshot>
+    <snapshotVersions>
+      <snapshotVersion><classifier>sources</classifier><extension>jar</extension><value>1.1-20210801.101010-3</value></snapshotVersion>
+      <snapshotVersion><extension>jar</extension><value>1.1-20210801.101010-3</value></snapshotVersion>
+    </snapshotVersions>
+  </versioning>
+</metadata>`
+	repo := &testRepository{
+		files: map[string]string{
+			"/snapshots/com/example/lib/1.1-SNAPSHOT/maven-metadata.xml":                    metadata,
+			"/snapshots/com/example/lib/1.1-SNAPSHOT/lib-1.1-20210801.101010-3-sources.jar": "snapshot",
+		},
+		requests: map[string]int{},
+	}
+	r, done := newTestResolver(t, repo)
+	defer done()
+
+	p, err := r.Resolve(Artifact{GroupID: "com.example", ArtifactID: "lib", Version: "1.1-SNAPSHOT", Classifier: "sources"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(p, "lib-1.1-20210801.101010-3-sources.jar") {
+		t.Errorf("snapshot resolved to %v", p)
+	}
+	// snapshots are disabled in the mirror of central
+	if n := repo.count("/public/com/example/lib/1.1-SNAPSHOT/maven-metadata.xml"); n != 0 {
+		t.Errorf("snapshot asked from central")
+	}
+}
+
+func TestResolveNotFound(t *testing.T) {
+	repo := &testRepository{files: map[string]string{}, requests: map[string]int{}}
+	r, done := newTestResolver(t, repo)
+	defer done()
+
+	artifact := Artifact{GroupID: "com.example", ArtifactID: "missing", Version: "1.0", Classifier: "sources"}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(artifact); err != ErrNotFound {
+			t.Fatalf("resolve error = %v, want not found", err)
+		}
+	}
+	if n := repo.count("/public/com/example/missing/1.0/missing-1.0-sources.jar"); n != 1 {
+		t.Errorf("asked %v times, want the not found kept", n)
+	}
+
+	r.Settings.Servers = nil
+	_, err := r.Resolve(Artifact{GroupID: "com.example", ArtifactID: "lib", Version: "2.0"})
+	if err == nil || err == ErrNotFound {
+		t.Errorf("unauthorized error = %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maven downloads artifacts from maven repositories by coordinates, with the mirrors
+// and servers of a settings.xml, into a local repository kept across runs.
+package maven
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no repository has the artifact
+var ErrNotFound = errors.New("artifact not found")
+
+// how long an artifact not found in the repositories is not asked again
+const notFoundTTL = 24 * time.Hour
+
+const notFoundSuffix = ".notfound"
+
+// Artifact is the coordinates of a maven artifact
+type Artifact struct {
+	GroupID    string
+	ArtifactID string
+	Version    string
+	Classifier string
+	// jar by default
+	Extension string
+}
+
+func (a Artifact) String() string {
+	s := a.GroupID + ":" + a.ArtifactID + ":" + a.Version
+	if a.Classifier != "" {
+		s += ":" + a.Classifier
+	}
+	return s
+}
+
+func (a Artifact) extension() string {
+	if a.Extension != "" {
+		return a.Extension
+	}
+	return "jar"
+}
+
+func (a Artifact) isSnapshot() bool {
+	return strings.HasSuffix(a.Version, "-SNAPSHOT")
+}
+
+// dir is the path of the version dir in a repository
+func (a Artifact) dir() string {
+	return path.Join(strings.ReplaceAll(a.GroupID, ".", "/"), a.ArtifactID, a.Version)
+}
+
+// fileName is the file name of the artifact, version is the timestamped version of a snapshot
+func (a Artifact) fileName(version string) string {
+	name := a.ArtifactID + "-" + version
+	if a.Classifier != "" {
+		name += "-" + a.Classifier
+	}
+	return name + "." + a.extension()
+}
+
+// Resolver downloads artifacts into a local repository
+type Resolver struct {
+	Settings *Settings
+	// the local repository, in the layout of ~/.m2/repository
+	LocalRepository string
+	HTTPClient      *http.Client
+}
+
+func NewResolver(settings *Settings, localRepository string) *Resolver {
+	if settings == nil {
+		settings = &Settings{}
+	}
+	return &Resolver{
+		Settings:        settings,
+		LocalRepository: localRepository,
+		HTTPClient:      &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Resolve returns the local path of an artifact, downloaded from the first repository that has it.
+// ErrNotFound is returned if no repository has it.
+func (r *Resolver) Resolve(artifact Artifact) (string, error) {
+	if artifact.GroupID == "" || artifact.ArtifactID == "" || artifact.Version == "" {
+		return "", fmt.Errorf("invalid artifact %v", artifact)
+	}
+	local := filepath.Join(r.LocalRepository, filepath.FromSlash(artifact.dir()), artifact.fileName(artifact.Version))
+	if !artifact.isSnapshot() {
+		if _, err := os.Stat(local); err == nil {
+			return local, nil
+		}
+	}
+	if info, err := os.Stat(local + notFoundSuffix); err == nil && time.Since(info.ModTime()) < notFoundTTL {
+		return "", ErrNotFound
+	}
+
+	var errs []string
+	for _, repo := range r.Settings.Repositories() {
+		if artifact.isSnapshot() && !repo.Snapshots.enabled() || !artifact.isSnapshot() && !repo.Releases.enabled() {
+			continue
+		}
+		p, err := r.download(repo, artifact)
+		if err == nil {
+			os.Remove(local + notFoundSuffix)
+			return p, nil
+		}
+		if err != ErrNotFound {
+			errs = append(errs, fmt.Sprintf("%v: %v", repo.ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		// an unavailable repository may have the artifact, so it is asked again next time
+		return "", fmt.Errorf("resolve %v error %v", artifact, strings.Join(errs, "; "))
+	}
+	markNotFound(local)
+	return "", ErrNotFound
+}
+
+// download downloads an artifact from a repository into the local repository, a snapshot is kept by its timestamped version
+func (r *Resolver) download(repo Repository, artifact Artifact) (string, error) {
+	version := artifact.Version
+	if artifact.isSnapshot() {
+		var err error
+		version, err = r.snapshotVersion(repo, artifact)
+		if err != nil {
+			return "", err
+		}
+	}
+	local := filepath.Join(r.LocalRepository, filepath.FromSlash(artifact.dir()), artifact.fileName(version))
+	if version != artifact.Version && fileExists(local) {
+		return local, nil
+	}
+	url := repo.URL + "/" + artifact.dir() + "/" + artifact.fileName(version)
+
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return "", err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(local), ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temp.Name())
+
+	hash := sha1.New()
+	err = r.get(repo, url, func(body io.Reader) error {
+		_, err := io.Copy(io.MultiWriter(temp, hash), body)
+		return err
+	})
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// the checksum is verified when the repository has it
+	var checksum string
+	err = r.get(repo, url+".sha1", func(body io.Reader) error {
+		data, err := ioutil.ReadAll(io.LimitReader(body, 1024))
+		checksum = strings.ToLower(strings.TrimSpace(string(data)))
+		return err
+	})
+	if err != nil && err != ErrNotFound {
+		return "", err
+	}
+	if fields := strings.Fields(checksum); len(fields) > 0 && fields[0] != hex.EncodeToString(hash.Sum(nil)) {
+		return "", fmt.Errorf("checksum of %v mismatch", url)
+	}
+	return local, os.Rename(temp.Name(), local)
+}
+
+type metadata struct {
+	Versioning struct {
+		Snapshot struct {
+			Timestamp   string `xml:"timestamp"`
+			BuildNumber string `xml:"buildNumber"`
+		} `xml:"snapshot"`
+		SnapshotVersions []struct {
+			Classifier string `xml:"classifier"`
+			Extension  string `xml:"extension"`
+			Value      string `xml:"value"`
+		} `xml:"snapshotVersions>snapshotVersion"`
+	} `xml:"versioning"`
+}
+
+// snapshotVersion returns the timestamped version of the latest build of a snapshot in the repository
+func (r *Resolver) snapshotVersion(repo Repository, artifact Artifact) (string, error) {
+	var m metadata
+	err := r.get(repo, repo.URL+"/"+artifact.dir()+"/maven-metadata.xml", func(body io.Reader) error {
+		return xml.NewDecoder(body).Decode(&m)
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, v := range m.Versioning.SnapshotVersions {
+		if v.Classifier == artifact.Classifier && v.Extension == artifact.extension() && v.Value != "" {
+			return v.Value, nil
+		}
+	}
+	if m.Versioning.Snapshot.Timestamp != "" && m.Versioning.Snapshot.BuildNumber != "" {
+		return strings.TrimSuffix(artifact.Version, "SNAPSHOT") + m.Versioning.Snapshot.Timestamp + "-" + m.Versioning.Snapshot.BuildNumber, nil
+	}
+	// a snapshot deployed without unique versions
+	return artifact.Version, nil
+}
+
+// get reads the body of a url of a repository with the credentials of its server
+func (r *Resolver) get(repo Repository, url string, read func(body io.Reader) error) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if server := r.Settings.server(repo.ID); server != nil {
+		req.SetBasicAuth(server.Username, server.Password)
+	}
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("get %v status %v", url, resp.StatusCode)
+	}
+	return read(resp.Body)
+}
+
+func (r *Resolver) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func markNotFound(local string) {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return
+	}
+	ioutil.WriteFile(local+notFoundSuffix, nil, 0644)
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maven
+
+import (
+	"encoding/xml"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// CentralURL is the url of the maven central repository
+const CentralURL = "https://repo.maven.apache.org/maven2"
+
+const centralID = "central"
+
+// Settings is the part of a maven settings.xml used to download artifacts
+type Settings struct {
+	Mirrors        []Mirror  `xml:"mirrors>mirror"`
+	Servers        []Server  `xml:"servers>server"`
+	Profiles       []Profile `xml:"profiles>profile"`
+	ActiveProfiles []string  `xml:"activeProfiles>activeProfile"`
+}
+
+type Mirror struct {
+	ID       string `xml:"id"`
+	MirrorOf string `xml:"mirrorOf"`
+	URL      string `xml:"url"`
+}
+
+type Server struct {
+	ID       string `xml:"id"`
+	Username string `xml:"username"`
+	Password string `xml:"password"`
+}
+
+type Profile struct {
+	ID           string       `xml:"id"`
+	Activation   Activation   `xml:"activation"`
+	Repositories []Repository `xml:"repositories>repository"`
+}
+
+type Activation struct {
+	ActiveByDefault bool `xml:"activeByDefault"`
+}
+
+type Repository struct {
+	ID        string     `xml:"id"`
+	URL       string     `xml:"url"`
+	Releases  RepoPolicy `xml:"releases"`
+	Snapshots RepoPolicy `xml:"snapshots"`
+}
+
+type RepoPolicy struct {
+	// nil means enabled
+	Enabled *bool `xml:"enabled"`
+}
+
+func (p RepoPolicy) enabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+var envExpr = regexp.MustCompile(`\$\{env\.([A-Za-z0-9_]+)\}`)
+
+// ParseSettings parses a settings.xml, ${env.NAME} in the values are replaced with the env of the agent
+func ParseSettings(data []byte) (*Settings, error) {
+	var settings Settings
+	if len(strings.TrimSpace(string(data))) <= 0 {
+		return &settings, nil
+	}
+	expanded := envExpr.ReplaceAllStringFunc(string(data), func(expr string) string {
+		return os.Getenv(envExpr.FindStringSubmatch(expr)[1])
+	})
+	if err := xml.Unmarshal([]byte(expanded), &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Repositories returns the repositories of the active profiles and central, each replaced by its mirror
+func (s *Settings) Repositories() []Repository {
+	var active = map[string]bool{}
+	for _, id := range s.ActiveProfiles {
+		active[id] = true
+	}
+
+	var repos []Repository
+	for _, profile := range s.Profiles {
+		if !active[profile.ID] && !profile.Activation.ActiveByDefault {
+			continue
+		}
+		repos = append(repos, profile.Repositories...)
+	}
+	repos = append(repos, Repository{ID: centralID, URL: CentralURL, Snapshots: RepoPolicy{Enabled: new(bool)}})
+
+	var result []Repository
+	var seen = map[string]bool{}
+	for _, repo := range repos {
+		if mirror := s.mirrorOf(repo); mirror != nil {
+			repo = Repository{ID: mirror.ID, URL: mirror.URL, Releases: repo.Releases, Snapshots: repo.Snapshots}
+		}
+		repo.URL = strings.TrimSuffix(repo.URL, "/")
+		if key := repo.ID + " " + repo.URL; !seen[key] {
+			seen[key] = true
+			result = append(result, repo)
+		}
+	}
+	return result
+}
+
+// mirrorOf returns the mirror of a repository, an exact id is matched before the patterns as maven does
+func (s *Settings) mirrorOf(repo Repository) *Mirror {
+	for i, mirror := range s.Mirrors {
+		if mirror.MirrorOf == repo.ID {
+			return &s.Mirrors[i]
+		}
+	}
+	for i, mirror := range s.Mirrors {
+		if matchMirrorOf(mirror.MirrorOf, repo) {
+			return &s.Mirrors[i]
+		}
+	}
+	return nil
+}
+
+// matchMirrorOf matches a mirrorOf as *, external:*, repo1,repo2 or *,!repo1
+func matchMirrorOf(mirrorOf string, repo Repository) bool {
+	var matched bool
+	for _, pattern := range strings.Split(mirrorOf, ",") {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case strings.HasPrefix(pattern, "!"):
+			if pattern[1:] == repo.ID {
+				return false
+			}
+		case pattern == "*":
+			matched = true
+		case pattern == "external:*":
+			matched = matched || !isLocalURL(repo.URL)
+		case pattern == repo.ID:
+			matched = true
+		}
+	}
+	return matched
+}
+
+func isLocalURL(url string) bool {
+	return strings.HasPrefix(url, "file:") || strings.Contains(url, "://localhost") || strings.Contains(url, "://127.0.0.1")
+}
+
+// server returns the credentials of a repository or mirror id
+func (s *Settings) server(id string) *Server {
+	for i, server := range s.Servers {
+		if server.ID == id {
+			return &s.Servers[i]
+		}
+	}
+	return nil
+}
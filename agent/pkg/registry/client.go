@@ -0,0 +1,357 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// media types of the manifests accepted from the registry
+const (
+	MediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	defaultPlatformOS        = "linux"
+	maxManifestSize          = 4 << 20
+	acceptManifestMediaTypes = MediaTypeOCIIndex + ", " + MediaTypeOCIManifest + ", " + MediaTypeDockerList + ", " + MediaTypeDockerManifest
+)
+
+// Descriptor points to a manifest or a blob
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+// Manifest is an image manifest, or an index of the manifests of each platform
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Client pulls images with the OCI distribution api
+type Client struct {
+	HTTPClient *http.Client
+	Keychain   Keychain
+	// registries served over plain http
+	Insecure []string
+	// platform picked from a multi-platform image, linux and the arch of the agent by default
+	OS           string
+	Architecture string
+
+	lock   sync.Mutex
+	tokens map[string]string
+}
+
+// NewClient returns a client authenticating with the keychain
+func NewClient(keychain Keychain) *Client {
+	return &Client{
+		// no timeout, a layer is read in a single response for as long as the context of the pull allows
+		HTTPClient: &http.Client{},
+		Keychain:   keychain,
+	}
+}
+
+// Manifest returns the image manifest of the reference, resolving an index to the manifest of the platform
+func (c *Client) Manifest(ctx context.Context, ref *Reference) (*Manifest, error) {
+	manifest, err := c.manifest(ctx, ref, ref.manifestRef())
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Manifests) <= 0 {
+		return manifest, nil
+	}
+
+	osName, arch := c.platform()
+	for _, desc := range manifest.Manifests {
+		if desc.Platform != nil && desc.Platform.OS == osName && desc.Platform.Architecture == arch {
+			return c.manifest(ctx, ref, desc.Digest)
+		}
+	}
+	return nil, fmt.Errorf("image %v has no %v/%v manifest", ref, osName, arch)
+}
+
+func (c *Client) platform() (string, string) {
+	osName, arch := c.OS, c.Architecture
+	if osName == "" {
+		osName = defaultPlatformOS
+	}
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	return osName, arch
+}
+
+func (c *Client) manifest(ctx context.Context, ref *Reference, manifestRef string) (*Manifest, error) {
+	resp, err := c.get(ctx, ref, "/manifests/"+manifestRef, acceptManifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %v error %v", manifestRef, err)
+	}
+	if strings.HasPrefix(manifestRef, "sha256:") {
+		if digest := sha256Digest(data); digest != manifestRef {
+			return nil, fmt.Errorf("manifest digest %v mismatch %v", digest, manifestRef)
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest %v error %v", manifestRef, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = resp.Header.Get("Content-Type")
+	}
+	return &manifest, nil
+}
+
+// ImageConfig is the part of the config of an image used by the agent
+type ImageConfig struct {
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// Config returns the config of the image of the reference
+func (c *Client) Config(ctx context.Context, ref *Reference) (*ImageConfig, error) {
+	manifest, err := c.Manifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.Blob(ctx, ref, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("read config %v error %v", manifest.Config.Digest, err)
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse config %v error %v", manifest.Config.Digest, err)
+	}
+	return &config, nil
+}
+
+// Blob returns the content of a blob, the digest is verified when it is read to the end
+func (c *Client) Blob(ctx context.Context, ref *Reference, desc Descriptor) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, ref, "/blobs/"+desc.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(desc.Digest, "sha256:") {
+		return resp.Body, nil
+	}
+	return &verifyReader{body: resp.Body, hash: sha256.New(), digest: desc.Digest}, nil
+}
+
+func (c *Client) get(ctx context.Context, ref *Reference, path string, accept string) (*http.Response, error) {
+	u := c.baseURL(ref.Registry) + "/v2/" + ref.Repository + path
+	key := ref.Registry + "/" + ref.Repository
+
+	var resp *http.Response
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		c.authorize(req, ref.Registry, key)
+
+		resp, err = c.httpClient().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("get %v error %v", u, err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			break
+		}
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.login(ctx, ref, key, challenge); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("get %v status %v: %v", u, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) baseURL(registry string) string {
+	for _, insecure := range c.Insecure {
+		if insecure == registry {
+			return "http://" + registry
+		}
+	}
+	return "https://" + registry
+}
+
+func (c *Client) authorize(req *http.Request, registry string, key string) {
+	c.lock.Lock()
+	token, ok := c.tokens[key]
+	c.lock.Unlock()
+	if ok {
+		req.Header.Set("Authorization", token)
+	}
+}
+
+// login gets the authorization asked by the challenge of the registry, a bearer token of the repository
+// or basic auth, and keeps it for the next requests
+func (c *Client) login(ctx context.Context, ref *Reference, key string, challenge string) error {
+	credential, hasCredential := c.Keychain[ref.Registry]
+	scheme, params := parseChallenge(challenge)
+
+	var authorization string
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCredential {
+			return fmt.Errorf("registry %v requires a credential", ref.Registry)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(credential.Username, credential.Password)
+		authorization = req.Header.Get("Authorization")
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return fmt.Errorf("registry %v invalid auth realm %v", ref.Registry, params["realm"])
+		}
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		query.Set("scope", "repository:"+ref.Repository+":pull")
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return err
+		}
+		if hasCredential {
+			req.SetBasicAuth(credential.Username, credential.Password)
+		}
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return fmt.Errorf("get registry %v token error %v", ref.Registry, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("get registry %v token status %v", ref.Registry, resp.StatusCode)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return fmt.Errorf("parse registry %v token error %v", ref.Registry, err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		authorization = "Bearer " + token.Token
+	default:
+		return fmt.Errorf("registry %v unsupported auth %v", ref.Registry, challenge)
+	}
+
+	c.lock.Lock()
+	if c.tokens == nil {
+		c.tokens = map[string]string{}
+	}
+	c.tokens[key] = authorization
+	c.lock.Unlock()
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header as Bearer realm="...",service="..."
+func parseChallenge(challenge string) (string, map[string]string) {
+	var params = map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return parts[0], params
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// verifyReader checks the digest of the blob at the end of the content
+type verifyReader struct {
+	body   io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if digest := "sha256:" + hex.EncodeToString(r.hash.Sum(nil)); digest != r.digest {
+			return n, fmt.Errorf("blob digest %v mismatch %v", digest, r.digest)
+		}
+	}
+	return n, err
+}
+
+func (r *verifyReader) Close() error {
+	return r.body.Close()
+}
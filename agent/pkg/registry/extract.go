@@ -0,0 +1,285 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const whiteoutPrefix = ".wh."
+const whiteoutOpaque = ".wh..wh..opq"
+
+// how many links are followed to resolve a link
+const maxLinkDepth = 40
+
+// Extract applies the layers of the image in order, writing the files under each dir of the image
+// into its dest dir of dirs. Files for which skip returns true are not written.
+func (c *Client) Extract(ctx context.Context, ref *Reference, dirs map[string]string, skip func(name string) bool) error {
+	manifest, err := c.Manifest(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	var mappings []dirMapping
+	for src, dest := range dirs {
+		src = strings.Trim(path.Clean("/"+src), "/")
+		dest = filepath.Clean(dest)
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		mappings = append(mappings, dirMapping{src: src, dest: dest})
+	}
+
+	for _, layer := range manifest.Layers {
+		if err := c.extractLayer(ctx, ref, layer, mappings, skip); err != nil {
+			return fmt.Errorf("extract layer %v of %v error %v", layer.Digest, ref, err)
+		}
+	}
+	return nil
+}
+
+type dirMapping struct {
+	src  string
+	dest string
+}
+
+// destOf returns the dest path of a file of the image with the dest dir it is under, false if it is not under a dir to extract
+func destOf(mappings []dirMapping, name string) (string, string, bool) {
+	for _, m := range mappings {
+		if m.src == "" {
+			return filepath.Join(m.dest, filepath.FromSlash(name)), m.dest, true
+		}
+		if name == m.src {
+			return m.dest, m.dest, true
+		}
+		if strings.HasPrefix(name, m.src+"/") {
+			return filepath.Join(m.dest, filepath.FromSlash(name[len(m.src)+1:])), m.dest, true
+		}
+	}
+	return "", "", false
+}
+
+// checkParents fails if a dir between root and dest is a link, the files of a layer are never written through
+// a link, as one to a dir out of root extracted from an earlier entry
+func checkParents(root string, dest string) error {
+	for p := filepath.Dir(dest); p != root && strings.HasPrefix(p, root+string(filepath.Separator)); p = filepath.Dir(p) {
+		info, err := os.Lstat(p)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%v is a link", p)
+		}
+	}
+	return nil
+}
+
+// resolveLink returns the path a relative link in dir points to, following the links extracted already,
+// false if it leaves root
+func resolveLink(root string, dir string, linkName string, depth int) (string, bool) {
+	if depth > maxLinkDepth || path.IsAbs(linkName) {
+		return "", false
+	}
+	current := dir
+	for _, part := range strings.Split(linkName, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if current == root {
+				return "", false
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+		next := filepath.Join(current, part)
+		if info, err := os.Lstat(next); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(next)
+			if err != nil {
+				return "", false
+			}
+			resolved, ok := resolveLink(root, current, target, depth+1)
+			if !ok {
+				return "", false
+			}
+			next = resolved
+		}
+		current = next
+	}
+	return current, true
+}
+
+func (c *Client) extractLayer(ctx context.Context, ref *Reference, layer Descriptor, mappings []dirMapping, skip func(name string) bool) error {
+	blob, err := c.Blob(ctx, ref, layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	reader, err := decompress(blob)
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.Trim(path.Clean("/"+header.Name), "/")
+		dir, base := path.Split(name)
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			if err := applyWhiteout(mappings, path.Join(dir, base), base); err != nil {
+				return fmt.Errorf("extract %v error %v", name, err)
+			}
+			continue
+		}
+
+		dest, root, ok := destOf(mappings, name)
+		if !ok || (skip != nil && skip(name)) {
+			continue
+		}
+		// the dest dir is only ever a dir
+		if dest == root && header.Typeflag != tar.TypeDir {
+			continue
+		}
+		if err := checkParents(root, dest); err != nil {
+			return fmt.Errorf("extract %v error %v", name, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dest, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(dest, tarReader, header.FileInfo().Mode())
+		case tar.TypeLink:
+			linkName := strings.Trim(path.Clean("/"+header.Linkname), "/")
+			if source, sourceRoot, ok := destOf(mappings, linkName); ok {
+				if err = checkParents(sourceRoot, source); err == nil {
+					err = copyFile(source, dest)
+				}
+			}
+		case tar.TypeSymlink:
+			// an absolute link points into the image, not the agent fs, and a link out of the dest dir is not followed
+			if _, ok := resolveLink(root, filepath.Dir(dest), header.Linkname, 0); ok {
+				os.RemoveAll(dest)
+				if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+					err = os.Symlink(header.Linkname, dest)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("extract %v error %v", name, err)
+		}
+	}
+
+	// read to the end, so the digest of the blob is verified
+	_, err = io.Copy(ioutil.Discard, blob)
+	return err
+}
+
+// applyWhiteout removes the files deleted by an upper layer
+func applyWhiteout(mappings []dirMapping, name string, base string) error {
+	if base == whiteoutOpaque {
+		dest, root, ok := destOf(mappings, strings.TrimSuffix(path.Dir(name), "/"))
+		if !ok {
+			return nil
+		}
+		if info, err := os.Lstat(dest); err != nil || !info.IsDir() {
+			return nil
+		}
+		if err := checkParents(root, dest); err != nil {
+			return err
+		}
+		entries, _ := ioutil.ReadDir(dest)
+		for _, entry := range entries {
+			os.RemoveAll(filepath.Join(dest, entry.Name()))
+		}
+		return nil
+	}
+	dest, root, ok := destOf(mappings, path.Join(path.Dir(name), strings.TrimPrefix(base, whiteoutPrefix)))
+	if !ok {
+		return nil
+	}
+	if err := checkParents(root, dest); err != nil {
+		return err
+	}
+	os.RemoveAll(dest)
+	return nil
+}
+
+// decompress returns the tar stream of a layer, gzip layers are detected by their magic bytes
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	if len(magic) >= 4 && magic[0] == 0x28 && magic[1] == 0xb5 && magic[2] == 0x2f && magic[3] == 0xfd {
+		return nil, fmt.Errorf("zstd layers are not supported")
+	}
+	return buffered, nil
+}
+
+func writeFile(dest string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	os.RemoveAll(dest)
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func copyFile(source string, dest string) error {
+	// a hard link is to a regular file of the layers, never to a link
+	if info, err := os.Lstat(source); err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeFile(dest, f, info.Mode())
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DockerHub is the registry of images without a registry host
+const DockerHub = "registry-1.docker.io"
+
+// Reference is an image in a registry, pulled by Digest if it is set, or else by Tag
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image name, or an image id of a pod container status
+// as docker-pullable://registry/repo@sha256:...
+func ParseReference(image string) (*Reference, error) {
+	for _, prefix := range []string{"docker-pullable://", "docker://"} {
+		image = strings.TrimPrefix(image, prefix)
+	}
+	if image == "" {
+		return nil, fmt.Errorf("empty image")
+	}
+
+	var ref Reference
+	if i := strings.Index(image, "@"); i >= 0 {
+		ref.Digest = image[i+1:]
+		image = image[:i]
+		if !strings.Contains(ref.Digest, ":") {
+			return nil, fmt.Errorf("invalid digest %v", ref.Digest)
+		}
+	}
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		ref.Tag = image[i+1:]
+		image = image[:i]
+	}
+
+	if i := strings.Index(image, "/"); i >= 0 && isRegistryHost(image[:i]) {
+		ref.Registry = image[:i]
+		ref.Repository = image[i+1:]
+	} else {
+		ref.Registry = DockerHub
+		ref.Repository = image
+	}
+	if ref.Registry == "docker.io" || ref.Registry == "index.docker.io" {
+		ref.Registry = DockerHub
+	}
+	if ref.Registry == DockerHub && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Repository == "" {
+		return nil, fmt.Errorf("invalid image %v", image)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return &ref, nil
+}
+
+func isRegistryHost(name string) bool {
+	return strings.ContainsAny(name, ".:") || name == "localhost"
+}
+
+// manifestRef is the digest, or else the tag, of the manifest
+func (r *Reference) manifestRef() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r *Reference) String() string {
+	var s = r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Credential is the login of a registry
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Keychain holds the credentials by registry host
+type Keychain map[string]Credential
+
+// ParseDockerConfig parses the data of a kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg secret
+// and adds its credentials into the keychain
+func (k Keychain) ParseDockerConfig(data []byte) error {
+	type authConfig struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	var config struct {
+		Auths map[string]authConfig `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if config.Auths == nil {
+		// the legacy .dockercfg is the auths map itself
+		if err := json.Unmarshal(data, &config.Auths); err != nil {
+			return err
+		}
+	}
+
+	for server, auth := range config.Auths {
+		var credential = Credential{Username: auth.Username, Password: auth.Password}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("invalid auth of %v", server)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid auth of %v", server)
+			}
+			credential = Credential{Username: parts[0], Password: parts[1]}
+		}
+		k[registryHostOf(server)] = credential
+	}
+	return nil
+}
+
+// registryHostOf returns the registry host of a docker config server as https://index.docker.io/v1/
+func registryHostOf(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		return DockerHub
+	}
+	return host
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2021 Terminus, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	for image, want := range map[string]Reference{
+		"nginx":                                  {Registry: DockerHub, Repository: "library/nginx", Tag: "latest"},
+		"docker.io/erda/agent:1.0":               {Registry: DockerHub, Repository: "erda/agent", Tag: "1.0"},
+		"localhost:5000/app/api":                 {Registry: "localhost:5000", Repository: "app/api", Tag: "latest"},
+		"registry.example.com/a/b:v1@sha256:abc": {Registry: "registry.example.com", Repository: "a/b", Tag: "v1", Digest: "sha256:abc"},
+		"docker-pullable://registry.example.com/a/b@sha256:abc": {Registry: "registry.example.com", Repository: "a/b", Digest: "sha256:abc"},
+	} {
+		ref, err := ParseReference(image)
+		if err != nil {
+			t.Errorf("parse %v error %v", image, err)
+			continue
+		}
+		if *ref != want {
+			t.Errorf("parse %v = %+v, want %+v", image, *ref, want)
+		}
+	}
+
+	if _, err := ParseReference("nginx@latest"); err == nil {
+		t.Errorf("invalid digest parsed")
+	}
+}
+
+func TestKeychainParseDockerConfig(t *testing.T) {
+	keychain := Keychain{}
+	err := keychain.ParseDockerConfig([]byte(`{"auths":{"https://index.docker.io/v1/":{"auth":"dXNlcjpwYXNz"},"registry.example.com":{"username":"u","password":"p"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keychain[DockerHub] != (Credential{Username: "user", Password: "pass"}) {
+		t.Errorf("docker hub = %+v", keychain[DockerHub])
+	}
+	if keychain["registry.example.com"] != (Credential{Username: "u", Password: "p"}) {
+		t.Errorf("registry = %+v", keychain["registry.example.com"])
+	}
+}
+
+type testFile struct {
+	name string
+	body string
+	dir  bool
+	link string
+}
+
+func testLayer(t *testing.T, files []testFile, compress bool) []byte {
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if f.dir {
+			header = &tar.Header{Name: f.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if f.link != "" {
+			header = &tar.Header{Name: f.name, Mode: 0777, Linkname: f.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// testRegistry serves a multi-platform image behind a bearer token
+func testRegistry(t *testing.T, layers [][]byte) *httptest.Server {
+	var blobs = map[string][]byte{}
+	config := []byte(`{"architecture":"amd64","config":{"Labels":{"org.opencontainers.image.revision":"abc123"}}}`)
+	blobs[sha256Digest(config)] = config
+	var manifest = Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest,
+		Config: Descriptor{Digest: sha256Digest(config), Size: int64(len(config))}}
+	for _, layer := range layers {
+		digest := sha256Digest(layer)
+		blobs[digest] = layer
+		manifest.Layers = append(manifest.Layers, Descriptor{Digest: digest, Size: int64(len(layer))})
+	}
+	manifestData, _ := json.Marshal(manifest)
+	manifestDigest := sha256Digest(manifestData)
+
+	armData, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest})
+	armDigest := sha256Digest(armData)
+
+	index, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{
+		{Digest: armDigest, Platform: &Platform{OS: "linux", Architecture: "arm64"}},
+		{Digest: manifestDigest, Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+	}})
+	var manifests = map[string][]byte{"v1": index, manifestDigest: manifestData, armDigest: armData}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"token":"secret"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		const prefix = "/v2/team/app/"
+		switch {
+		case strings.HasPrefix(r.URL.Path, prefix+"manifests/"):
+			data, ok := manifests[strings.TrimPrefix(r.URL.Path, prefix+"manifests/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case strings.HasPrefix(r.URL.Path, prefix+"blobs/"):
+			data, ok := blobs[strings.TrimPrefix(r.URL.Path, prefix+"blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestClientExtract(t *testing.T) {
+	base := testLayer(t, []testFile{
+		{name: "app/", dir: true},
+		{name: "app/app.jar", body: "v1"},
+		{name: "app/old.jar", body: "old"},
+		{name: "app/logs/app.log", body: "log"},
+		{name: "app/opaque/stale.jar", body: "stale"},
+		{name: "etc/passwd", body: "root"},
+		{name: "../escape.jar", body: "escape"},
+	}, true)
+	upper := testLayer(t, []testFile{
+		{name: "app/app.jar", body: "v2"},
+		{name: "app/.wh.old.jar"},
+		{name: "app/opaque/.wh..wh..opq"},
+		{name: "app/opaque/new.jar", body: "new"},
+	}, false)
+
+	server := testRegistry(t, [][]byte{base, upper})
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	dest, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	ref, err := ParseReference(host + "/team/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(Keychain{host: {Username: "user", Password: "pass"}})
+	client.Insecure = []string{host}
+	client.Architecture = "amd64"
+	client.Architecture = "amd64"
+	err = client.Extract(context.Background(), ref, map[string]string{"/app": dest}, func(name string) bool {
+		return strings.HasPrefix(name, "app/logs")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"app.jar": "v2", "opaque/new.jar": "new"} {
+		data, err := ioutil.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%v = %q %v, want %q", name, data, err, want)
+		}
+	}
+	for _, name := range []string{"old.jar", "logs", "opaque/stale.jar", "passwd", "etc"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err == nil {
+			t.Errorf("%v extracted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dest), "escape.jar")); err == nil {
+		t.Errorf("escape.jar extracted out of the dest")
+	}
+
+	config, err := client.Config(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision := config.Config.Labels["org.opencontainers.image.revision"]; revision != "abc123" {
+		t.Errorf("revision label = %q", revision)
+	}
+}
+
+func TestClientExtractLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "a", "b", "dest")
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	base := testLayer(t, []testFile{
+		{name: "app/libs/", dir: true},
+		{name: "app/libs/dto.jar", body: "dto"},
+		{name: "app/current", link: "libs"},
+		{name: "app/current.jar", link: "libs/dto.jar"},
+		{name: "app/up", link: "libs/.."},
+		// out of the dest dir, directly or through another link
+		{name: "app/escape", link: "../../../outside"},
+		{name: "app/libs/parent", link: ".."},
+		{name: "app/through", link: "libs/parent/../.."},
+		{name: "app/absolute", link: "/etc"},
+		{name: "app", link: "../outside"},
+	}, true)
+	server := testRegistry(t, [][]byte{base})
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	ref, err := ParseReference(host + "/team/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(Keychain{host: {Username: "user", Password: "pass"}})
+	client.Insecure = []string{host}
+	client.Architecture = "amd64"
+	if err := client.Extract(context.Background(), ref, map[string]string{"/app": dest}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"current/dto.jar", "current.jar", "up/libs/dto.jar", "libs/parent/libs/dto.jar"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dest, name)); err != nil || string(data) != "dto" {
+			t.Errorf("%v = %q %v", name, data, err)
+		}
+	}
+	for _, name := range []string{"escape", "through", "absolute"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); err == nil {
+			t.Errorf("link %v extracted", name)
+		}
+	}
+	if info, err := os.Lstat(dest); err != nil || !info.IsDir() {
+		t.Errorf("dest dir replaced by a link")
+	}
+
+	// a file is never written through a link to a dir, in the dest dir or out of it
+	upper := testLayer(t, []testFile{{name: "app/current/new.jar", body: "new"}}, false)
+	server = testRegistry(t, [][]byte{base, upper})
+	defer server.Close()
+	host = strings.TrimPrefix(server.URL, "http://")
+	ref, _ = ParseReference(host + "/team/app:v1")
+	client.Insecure = []string{host}
+	client.Keychain = Keychain{host: {Username: "user", Password: "pass"}}
+	err = client.Extract(context.Background(), ref, map[string]string{"/app": dest}, nil)
+	if err == nil || !strings.Contains(err.Error(), "is a link") {
+		t.Errorf("write through a link error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "libs", "new.jar")); err == nil {
+		t.Errorf("new.jar written through a link")
+	}
+	if entries, _ := ioutil.ReadDir(outside); len(entries) > 0 {
+		t.Errorf("%v files written out of the dest dir", len(entries))
+	}
+}
+
+func TestClientBlobDigestMismatch(t *testing.T) {
+	layer := testLayer(t, []testFile{{name: "app/app.jar", body: "v1"}}, true)
+	server := testRegistry(t, [][]byte{layer})
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	client := NewClient(Keychain{host: {Username: "user", Password: "pass"}})
+	client.Insecure = []string{host}
+	client.Architecture = "amd64"
+	ref := &Reference{Registry: host, Repository: "team/app"}
+	blob, err := client.Blob(context.Background(), ref, Descriptor{Digest: sha256Digest(layer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, err := ioutil.ReadAll(blob); err != nil {
+		t.Errorf("read blob error %v", err)
+	}
+
+	// the server returns the layer for its digest, read as another digest it must not verify
+	blob, err = client.Blob(context.Background(), ref, Descriptor{Digest: sha256Digest(layer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob.(*verifyReader).digest = sha256Digest([]byte("other"))
+	if _, err := ioutil.ReadAll(blob); err == nil {
+		t.Errorf("digest mismatch not detected")
+	}
+	blob.Close()
+}
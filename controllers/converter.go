@@ -89,6 +89,11 @@ func convertRole(app *appv1.Agent, setOwnerRef SetOwnerRefFunc) *rbacv1.Role {
 				Resources: []string{"statefulsets"},
 				Verbs:     []string{"get", "watch", "list"},
 			},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"daemonsets"},
+				Verbs:     []string{"get", "watch", "list"},
+			},
 			{
 				APIGroups: []string{""},
 				Resources: []string{"pods"},
@@ -99,6 +104,11 @@ func convertRole(app *appv1.Agent, setOwnerRef SetOwnerRefFunc) *rbacv1.Role {
 				Resources: []string{"pods/exec"},
 				Verbs:     []string{"create"},
 			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"secrets", "serviceaccounts"},
+				Verbs:     []string{"get"},
+			},
 		},
 	}
 